@@ -0,0 +1,38 @@
+package main
+
+import (
+	"../eioh"
+	"log"
+	"strconv"
+)
+
+var baselineCmd = &Command{
+	Name:    "baseline",
+	Usage:   "<version>",
+	Summary: "Mark every migration up to the given version as applied without running it",
+	Help:    `baseline extended help here...`,
+	Run:     baselineRun,
+}
+
+func baselineRun(cmd *Command, args ...string) {
+
+	if len(args) < 1 {
+		log.Fatal("eioh baseline: version required")
+	}
+
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.BaselineVersion(conf, version); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("eioh: baselined at version %d\n", version)
+}
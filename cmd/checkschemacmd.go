@@ -0,0 +1,30 @@
+package main
+
+import (
+	"../eioh"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+var checkSchemaCmd = &Command{
+	Name:    "check-schema",
+	Usage:   "",
+	Summary: "Fail if schema.<driver>.sql does not match the current schema of the database",
+	Help:    `check-schema extended help here...`,
+	Run:     checkSchemaRun,
+}
+
+func checkSchemaRun(cmd *Command, args ...string) {
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.CheckSchema(conf); err != nil {
+		exitOnError(err)
+	}
+
+	fmt.Printf("eioh: %s is up to date\n", filepath.Base(conf.SchemaFile))
+}
@@ -25,10 +25,9 @@ func createRun(cmd *Command, args ...string) {
 		return
 	}
 
-	// conf, err := dbConfFromFlags()
-	conf, err := eioh.NewDBConf("../", "development")
+	conf, err := dbConfFromFlags()
 	if err != nil {
-		// log.Fatal(err)
+		exitOnError(err)
 	}
 
 	if err = os.MkdirAll(conf.MigrationsDir, 0777); err != nil {
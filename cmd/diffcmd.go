@@ -0,0 +1,66 @@
+package main
+
+import (
+	"../eioh"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var diffCmd = &Command{
+	Name:    "diff",
+	Usage:   "-desired schema.sql -name <migration name>",
+	Summary: "Create a migration that takes the database to the schema in a desired-state file",
+	Help:    `diff extended help here...`,
+	Run:     diffRun,
+}
+
+var (
+	diffDesired string
+	diffName    string
+)
+
+func init() {
+	diffCmd.Flag.StringVar(&diffDesired, "desired", "", "SQL file with the desired schema")
+	diffCmd.Flag.StringVar(&diffName, "name", "", "name of the migration to create")
+}
+
+func diffRun(cmd *Command, args ...string) {
+
+	if diffDesired == "" || diffName == "" {
+		log.Fatal("eioh diff: -desired and -name are required")
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	up, down, err := eioh.DiffSchema(conf, diffDesired)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(up) == 0 {
+		fmt.Println("eioh: the database already matches", diffDesired)
+		return
+	}
+
+	if err = os.MkdirAll(conf.MigrationsDir, 0777); err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := eioh.CreateMigrationWithSQL(diffName, conf.MigrationsDir, time.Now(), up, down)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := filepath.Abs(n)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("eioh: created %s with %d statement(s), review the down section before applying\n", a, len(up))
+}
@@ -14,16 +14,25 @@ var downCmd = &Command{
 	Run:     downRun,
 }
 
+var downFromDB bool
+
+func init() {
+	downCmd.Flag.BoolVar(&downFromDB, "from-db", false, "roll back with the SQL stored when the migration was applied")
+}
+
 func downRun(cmd *Command, args ...string) {
 
-	// conf, err := dbConfFromFlags()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	
-	conf, err := eioh.NewDBConf("../", "development")
+	conf, err := dbConfFromFlags()
 	if err != nil {
-		// log.Fatal(err)
+		exitOnError(err)
+	}
+
+	if downFromDB {
+		if err = eioh.RollbackFromDB(conf); err != nil {
+			exitOnError(err)
+		}
+		writeSchema(conf)
+		return
 	}
 
 	current, err := eioh.GetDBVersion(conf)
@@ -43,6 +52,7 @@ func downRun(cmd *Command, args ...string) {
 	// fmt.Println(previous)
 
 	if err = eioh.RunMigrations(conf, conf.MigrationsDir, previous); err != nil {
-		log.Fatal(err)
+		exitOnError(err)
 	}
+	writeSchema(conf)
 }
\ No newline at end of file
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+)
+
+var dumpSchemaCmd = &Command{
+	Name:    "dump-schema",
+	Usage:   "",
+	Summary: "Write the current schema of the database to schema.<driver>.sql",
+	Help:    `dump-schema extended help here...`,
+	Run:     dumpSchemaRun,
+}
+
+func dumpSchemaRun(cmd *Command, args ...string) {
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writeSchema(conf)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"../eioh"
+	"log"
+	"strconv"
+)
+
+var forceCmd = &Command{
+	Name:    "force",
+	Usage:   "<version>",
+	Summary: "Clear a dirty state and record the given version as current",
+	Help:    `force extended help here...`,
+	Run:     forceRun,
+}
+
+func forceRun(cmd *Command, args ...string) {
+
+	if len(args) < 1 {
+		log.Fatal("eioh force: version required")
+	}
+
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.ForceVersion(conf, version); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("eioh: forced version %d\n", version)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"../eioh"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var generateCmd = &Command{
+	Name:    "generate",
+	Usage:   "-name <migration name> [-dialect mysql|postgres|sqlite3] tables.yml",
+	Summary: "Create a migration from portable YAML table definitions",
+	Help:    `generate extended help here...`,
+	Run:     generateRun,
+}
+
+var (
+	generateName    string
+	generateDialect string
+)
+
+func init() {
+	generateCmd.Flag.StringVar(&generateName, "name", "", "name of the migration to create")
+	generateCmd.Flag.StringVar(&generateDialect, "dialect", "", "dialect to emit (default: the environment's driver)")
+}
+
+func generateRun(cmd *Command, args ...string) {
+
+	if len(args) < 1 || generateName == "" {
+		log.Fatal("eioh generate: -name and a table definition file are required")
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dialect := generateDialect
+	if dialect == "" {
+		dialect = conf.Driver.Name
+	}
+
+	defs, err := eioh.ReadTableDefs(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	up, down, err := eioh.TableDefsSql(dialect, defs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = os.MkdirAll(conf.MigrationsDir, 0777); err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := eioh.CreateMigrationWithSQL(generateName, conf.MigrationsDir, time.Now(), up, down)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := filepath.Abs(n)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("eioh: created %s (%s, %d table(s))\n", a, dialect, len(defs))
+}
@@ -8,12 +8,11 @@ import (
 	"../eioh"
 	"../notification"
 	"text/template"
-
-	// "log"
+	"log"
 )
 
 // global options. available to any subcommands.
-var flagPath = flag.String("path", "db", "folder containing db info")
+var flagPath = flag.String("path", "../", "folder containing db info")
 var flagEnv = flag.String("env", "development", "which DB environment to use")
 // var flagPgSchema = flag.String("pgschema", "", "which postgres-schema to migrate (default = none)")
 
@@ -22,6 +21,32 @@ func dbConfFromFlags() (dbconf *eioh.DBConf, err error) {
 	return eioh.NewDBConf(*flagPath, *flagEnv)
 }
 
+// exitOnError prints err and exits. A failed statement is printed in
+// file:line form, which editors and CI logs can link to.
+func exitOnError(err error) {
+	if _, ok := err.(*eioh.MigrationError); ok {
+		fmt.Fprintln(os.Stderr, "FAIL", err)
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
+
+// writeSchema refreshes the schema file after a successful migration run.
+func writeSchema(conf *eioh.DBConf) {
+	if err := eioh.WriteSchema(conf); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// confirm asks a yes/no question on the terminal.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 
 
 //Sync 環境をシンクさせる
@@ -36,6 +61,18 @@ var commands = []*Command{
 	redoCmd,
 	statusCmd,
 	createCmd,
+	forceCmd,
+	validateCmd,
+	baselineCmd,
+	markAppliedCmd,
+	markRolledBackCmd,
+	rollbackBatchCmd,
+	testApplyCmd,
+	verifyRoundtripCmd,
+	dumpSchemaCmd,
+	checkSchemaCmd,
+	diffCmd,
+	generateCmd,
 	// dbVersionCmd,
 }
 
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"../eioh"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+var markAppliedCmd = &Command{
+	Name:    "mark-applied",
+	Usage:   "<version>",
+	Summary: "Record a migration as applied without running it",
+	Help:    `mark-applied extended help here...`,
+	Run:     markAppliedRun,
+}
+
+var markRolledBackCmd = &Command{
+	Name:    "mark-rolled-back",
+	Usage:   "<version>",
+	Summary: "Record a migration as rolled back without running it",
+	Help:    `mark-rolled-back extended help here...`,
+	Run:     markRolledBackRun,
+}
+
+var (
+	markYes      bool
+	markOperator string
+)
+
+func init() {
+	for _, c := range []*Command{markAppliedCmd, markRolledBackCmd} {
+		c.Flag.BoolVar(&markYes, "yes", false, "do not ask for confirmation in a protected environment")
+		c.Flag.StringVar(&markOperator, "operator", "", "name recorded in the history (default $EIOH_OPERATOR or the current user)")
+	}
+}
+
+func markAppliedRun(cmd *Command, args ...string) {
+	markRun(cmd, true, args...)
+}
+
+func markRolledBackRun(cmd *Command, args ...string) {
+	markRun(cmd, false, args...)
+}
+
+func markRun(cmd *Command, applied bool, args ...string) {
+
+	if len(args) < 1 {
+		log.Fatalf("eioh %s: version required", cmd.Name)
+	}
+
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	state := "applied"
+	if !applied {
+		state = "rolled back"
+	}
+
+	if conf.Protected && !markYes {
+		if !confirm(fmt.Sprintf("environment '%s' is protected. record version %d as %s without running it?", conf.Env, version, state)) {
+			log.Fatal("eioh: aborted")
+		}
+	}
+
+	if err = eioh.MarkVersion(conf, version, applied, operatorName()); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("eioh: marked version %d as %s\n", version, state)
+}
+
+func operatorName() string {
+	if markOperator != "" {
+		return markOperator
+	}
+	if name := os.Getenv("EIOH_OPERATOR"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"../eioh"
-	"log"
 )
 
 var redoCmd = &Command{
@@ -13,31 +12,21 @@ var redoCmd = &Command{
 	Run:     redoRun,
 }
 
-func redoRun(cmd *Command, args ...string) {
-	// conf, err := dbConfFromFlags()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	conf, err := eioh.NewDBConf("../", "development")
-	if err != nil {
-		// log.Fatal(err)
-	}
+var redoAllowDestructive bool
 
-	current, err := eioh.GetDBVersion(conf)
-	if err != nil {
-		log.Fatal(err)
-	}
+func init() {
+	redoCmd.Flag.BoolVar(&redoAllowDestructive, "allow-destructive", false, "re-apply a risky migration in a protected environment")
+}
 
-	previous, err := eioh.GetPreviousDBVersion(conf.MigrationsDir, current)
+func redoRun(cmd *Command, args ...string) {
+	conf, err := dbConfFromFlags()
 	if err != nil {
-		log.Fatal(err)
+		exitOnError(err)
 	}
+	conf.AllowDestructive = redoAllowDestructive
 
-	if err := eioh.RunMigrations(conf, conf.MigrationsDir, previous); err != nil {
-		log.Fatal(err)
+	if err := eioh.RedoMigration(conf); err != nil {
+		exitOnError(err)
 	}
-
-	if err := eioh.RunMigrations(conf, conf.MigrationsDir, current); err != nil {
-		log.Fatal(err)
-	}
-}
\ No newline at end of file
+	writeSchema(conf)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"../eioh"
+	"log"
+	"strconv"
+)
+
+var rollbackBatchCmd = &Command{
+	Name:    "rollback-batch",
+	Usage:   "[batch]",
+	Summary: "Roll back every migration applied by the last (or the given) batch",
+	Help:    `rollback-batch extended help here...`,
+	Run:     rollbackBatchRun,
+}
+
+func rollbackBatchRun(cmd *Command, args ...string) {
+
+	var batch int64
+	if len(args) > 0 {
+		b, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		batch = b
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.RollbackBatch(conf, batch); err != nil {
+		exitOnError(err)
+	}
+}
@@ -16,10 +16,9 @@ var statusCmd = &Command{
 
 func statusRun(cmd *Command, args ...string) {
 
-	// conf, err := dbConfFromFlags()
-	conf, err := eioh.NewDBConf("../", "development")
+	conf, err := dbConfFromFlags()
 	if err != nil {
-		// log.Fatal(err)
+		exitOnError(err)
 	}
 
 	// target, err := eioh.GetMostRecentDBVersion(conf.MigrationsDir)
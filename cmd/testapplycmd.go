@@ -0,0 +1,32 @@
+package main
+
+import (
+	"../eioh"
+	"log"
+)
+
+var testApplyCmd = &Command{
+	Name:    "test-apply",
+	Usage:   "",
+	Summary: "Run the pending migrations in a transaction that is always rolled back",
+	Help:    `test-apply extended help here...`,
+	Run:     testApplyRun,
+}
+
+var testApplyDown bool
+
+func init() {
+	testApplyCmd.Flag.BoolVar(&testApplyDown, "down", false, "also run the down sections, newest first")
+}
+
+func testApplyRun(cmd *Command, args ...string) {
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.TestApply(conf, testApplyDown); err != nil {
+		exitOnError(err)
+	}
+}
@@ -13,12 +13,27 @@ var upCmd = &Command{
 	Run:     upRun,
 }
 
+var (
+	upAllowDestructive bool
+	upAllowOutOfOrder  bool
+	upSingleTx         bool
+)
+
+func init() {
+	upCmd.Flag.BoolVar(&upAllowDestructive, "allow-destructive", false, "apply risky migrations in a protected environment")
+	upCmd.Flag.BoolVar(&upAllowOutOfOrder, "allow-out-of-order", false, "also apply pending migrations older than the current version")
+	upCmd.Flag.BoolVar(&upSingleTx, "single-transaction", false, "apply all pending migrations in one transaction, or none of them (postgres, sqlite3)")
+}
+
 func upRun(cmd *Command, args ...string) {
 
-	conf, err := eioh.NewDBConf("../", "development")
+	conf, err := dbConfFromFlags()
 	if err != nil {
-		// log.Fatal(err)
+		exitOnError(err)
 	}
+	conf.AllowDestructive = upAllowDestructive
+	conf.AllowOutOfOrder = upAllowOutOfOrder
+	conf.SingleTransaction = upSingleTx
 
 	target, err := eioh.GetMostRecentDBVersion(conf.MigrationsDir)
 	if err != nil {
@@ -26,6 +41,7 @@ func upRun(cmd *Command, args ...string) {
 	}
 
 	if err := eioh.RunMigrations(conf, conf.MigrationsDir, target); err != nil {
-		// log.Fatal(err)
+		exitOnError(err)
 	}
+	writeSchema(conf)
 }
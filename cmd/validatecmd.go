@@ -0,0 +1,50 @@
+package main
+
+import (
+	"../eioh"
+	"fmt"
+	"log"
+	"os"
+)
+
+var validateCmd = &Command{
+	Name:    "validate",
+	Usage:   "",
+	Summary: "Check every migration file for problems without connecting to the database",
+	Help:    `validate extended help here...`,
+	Run:     validateRun,
+}
+
+func validateRun(cmd *Command, args ...string) {
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	problems, err := eioh.ValidateMigrations(conf, conf.MigrationsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	// files with problems may not even be readable, leave them to be fixed first
+	findings, err := eioh.AnalyzeMigrations(conf, conf.MigrationsDir)
+	if err != nil && len(problems) == 0 {
+		log.Fatal(err)
+	}
+
+	for _, f := range findings {
+		fmt.Println("RISK", f)
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("eioh: %d problem(s) found\n", len(problems))
+		os.Exit(1)
+	}
+
+	fmt.Println("eioh: all migrations OK")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"../eioh"
+	"log"
+)
+
+var verifyRoundtripCmd = &Command{
+	Name:    "verify-roundtrip",
+	Usage:   "",
+	Summary: "Apply, roll back and re-apply each migration on the scratch database, checking that down undoes up",
+	Help:    `verify-roundtrip extended help here...`,
+	Run:     verifyRoundtripRun,
+}
+
+func verifyRoundtripRun(cmd *Command, args ...string) {
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = eioh.VerifyRoundtrip(conf); err != nil {
+		exitOnError(err)
+	}
+}
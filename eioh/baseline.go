@@ -0,0 +1,76 @@
+package eioh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// eioh導入前から存在するDBに、指定バージョンまで適用済みの印をつける
+
+// BaselineVersion records every migration up to and including v as applied
+// without running it, so that eioh can take over an existing database.
+func BaselineVersion(conf *DBConf, v int64) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := EnsureDBVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	if current > 0 {
+		return errors.New(fmt.Sprintf("database is already at version %d, baseline only applies to a fresh db_version", current))
+	}
+
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	var ms []*preparedMigration
+	sawVersion := false
+
+	err = filepath.Walk(conf.MigrationsDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		n, e := NumericComponent(name)
+		if e != nil || n > v {
+			return nil
+		}
+		sum, err := migrationChecksum(conf, name)
+		if err != nil {
+			return err
+		}
+		ms = append(ms, &preparedMigration{Source: name, Version: n, Direction: true, Checksum: sum, Kind: historyBaseline})
+		sawVersion = sawVersion || n == v
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// keep the marker even when v itself has no file
+	if !sawVersion {
+		ms = append(ms, &preparedMigration{Source: "baseline", Version: v, Direction: true, Kind: historyBaseline})
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if err = recordVersion(conf, txn, m); err != nil {
+			txn.Rollback()
+			return err
+		}
+		fmt.Println("BASELINE", filepath.Base(m.Source))
+	}
+
+	return txn.Commit()
+}
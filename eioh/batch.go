@@ -0,0 +1,124 @@
+package eioh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// 一回のupで適用したマイグレーションをまとめてロールバックする
+
+// batchVersions returns the applied versions of every batch. A version
+// belongs to the batch of the latest run that applied it.
+func batchVersions(conf *DBConf, db *sql.DB) (map[int64][]int64, error) {
+
+	applied, err := AppliedVersions(conf, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conf.Driver.Base.batchQuery(db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[int64]bool)
+	batches := make(map[int64][]int64)
+
+	for rows.Next() {
+		var v, batch int64
+		if err = rows.Scan(&v, &batch); err != nil {
+			return nil, err
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		if batch > 0 && applied[v] {
+			batches[batch] = append(batches[batch], v)
+		}
+	}
+
+	return batches, rows.Err()
+}
+
+// RollbackBatch rolls back, newest first, the migrations applied by batch,
+// or by the most recent batch still applied when batch is 0.
+func RollbackBatch(conf *DBConf, batch int64) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err = EnsureDBVersion(conf, db); err != nil {
+		return err
+	}
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	batches, err := batchVersions(conf, db)
+	if err != nil {
+		return err
+	}
+
+	if batch == 0 {
+		for b := range batches {
+			if b > batch {
+				batch = b
+			}
+		}
+		if batch == 0 {
+			return errors.New("no batch to roll back")
+		}
+	}
+
+	versions := batches[batch]
+	if len(versions) == 0 {
+		return errors.New(fmt.Sprintf("batch %d has no applied migrations", batch))
+	}
+	sort.Sort(sort.Reverse(int64Slice(versions)))
+
+	var next int64
+	if err = conf.Driver.Base.nextBatchQuery(db).Scan(&next); err != nil {
+		return err
+	}
+
+	fmt.Printf("eioh: rolling back batch %d in db environment '%v': %v\n", batch, conf.Env, versions)
+
+	for _, v := range versions {
+
+		files, err := CollectMigrations(conf.MigrationsDir, v-1, v)
+		if err != nil {
+			return err
+		}
+
+		if len(files) == 0 {
+			err = rollbackFromHistory(conf, db, v, next)
+		} else {
+			err = runSQLMigration(conf, db, files[0].Source, v, false, next)
+			if err == nil {
+				fmt.Println("OK   ", filepath.Base(files[0].Source))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
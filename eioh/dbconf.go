@@ -2,9 +2,10 @@ package eioh
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
-	"fmt"
+	"strings"
 
 	"github.com/kylelemons/go-gypsy/yaml"
 )
@@ -12,13 +13,39 @@ import (
 type DBDriver struct {
 	Name    string
 	OpenStr string
-	Base SqlBase
+	Base    SqlBase
 }
 
+const (
+	defaultLargeTableMB = 1024
+	defaultLockTimeout  = 10
+)
+
 type DBConf struct {
 	MigrationsDir string
+	SchemaFile    string // normalized dump of the schema, for reviewers
 	Env           string
 	Driver        DBDriver
+	Protected     bool
+	Shadow        bool              // try pending migrations on a shadow copy of the schema first
+	RiskRules     map[string]string // risk rule name -> level
+	Vars          map[string]string // substituted into migration SQL
+
+	// tables larger than this (in bytes) are flagged when a migration rewrites them
+	LargeTableSize int64
+
+	// seconds DDL may wait for a lock before failing, 0 leaves the server default
+	LockTimeout int
+	// seconds to wait for open transactions on altered tables before giving up
+	PreflightWait int
+
+	// throwaway database that verify-roundtrip applies migrations to
+	Scratch DBDriver
+
+	// set from command line flags
+	AllowDestructive  bool
+	AllowOutOfOrder   bool
+	SingleTransaction bool
 }
 
 func NewDBConf(p, env string) (*DBConf, error) {
@@ -52,13 +79,187 @@ func NewDBConf(p, env string) (*DBConf, error) {
 	// if base, err := f.Get(fmt.Sprintf("%s.base", env)); err == nil {
 	// 	d.Base = baseByName(base)
 	// }
+	protected, err := f.GetBool(fmt.Sprintf("%s.protected", env))
+	if err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); !ok {
+			return nil, err
+		}
+	}
+
+	// on by default in protected environments
+	shadow, err := f.GetBool(fmt.Sprintf("%s.shadow", env))
+	if err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); !ok {
+			return nil, err
+		}
+		shadow = protected
+	}
+
+	rules, err := riskRulesFromYaml(f, env)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := varsFromYaml(f, env)
+	if err != nil {
+		return nil, err
+	}
+
+	largeMB, err := optionalInt(f, fmt.Sprintf("%s.large_table_mb", env), defaultLargeTableMB)
+	if err != nil {
+		return nil, err
+	}
+
+	lockTimeout, err := optionalInt(f, fmt.Sprintf("%s.lock_timeout", env), defaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	preflightWait, err := optionalInt(f, fmt.Sprintf("%s.preflight_wait", env), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := scratchFromYaml(f, env, drv)
+	if err != nil {
+		return nil, err
+	}
+
+	// one dump per dialect, unless an environment's migrations
+	// differ ('-- +eioh env') and it names a file of its own
+	schemaFile, err := f.Get(fmt.Sprintf("%s.schema_file", env))
+	if err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); !ok {
+			return nil, err
+		}
+		schemaFile = fmt.Sprintf("schema.%s.sql", drv)
+	}
+
 	return &DBConf{
-		MigrationsDir: filepath.Join(p, "migrations"),
-		Env:           env,
-		Driver:        d,
+		MigrationsDir:  filepath.Join(p, "migrations"),
+		SchemaFile:     filepath.Join(p, schemaFile),
+		Env:            env,
+		Driver:         d,
+		Protected:      protected,
+		Shadow:         shadow,
+		RiskRules:      rules,
+		Vars:           vars,
+		LargeTableSize: largeMB << 20,
+		LockTimeout:    int(lockTimeout),
+		PreflightWait:  int(preflightWait),
+		Scratch:        scratch,
 	}, nil
 }
 
+// optionalInt returns the integer at spec, or def when it is not set.
+func optionalInt(f *yaml.File, spec string, def int64) (int64, error) {
+	n, err := f.GetInt(spec)
+	if err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); ok {
+			return def, nil
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// riskRulesFromYaml reads the optional '<env>.risk' map of rule levels, e.g.
+//
+//	risk:
+//	    missing-online-hints: off
+//	    blocking-lock: block
+func riskRulesFromYaml(f *yaml.File, env string) (map[string]string, error) {
+
+	rules := make(map[string]string)
+
+	node, err := yaml.Child(f.Root, fmt.Sprintf("%s.risk", env))
+	if _, ok := err.(*yaml.NodeNotFound); ok || (err == nil && node == nil) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := node.(yaml.Map)
+	if !ok {
+		return nil, fmt.Errorf("%s.risk: expected a map of rule names to levels", env)
+	}
+
+	for name, v := range m {
+		if riskRuleByName(name) == nil {
+			return nil, fmt.Errorf("%s.risk.%s: unknown rule, expected one of %s", env, name, riskRuleNames())
+		}
+		level, ok := v.(yaml.Scalar)
+		if !ok {
+			return nil, fmt.Errorf("%s.risk.%s: expected one of off, warn, block", env, name)
+		}
+		switch l := strings.TrimSpace(level.String()); l {
+		case RiskOff, RiskWarn, RiskBlock:
+			rules[name] = l
+		default:
+			return nil, fmt.Errorf("%s.risk.%s: unknown level %q", env, name, l)
+		}
+	}
+
+	return rules, nil
+}
+
+// varsFromYaml reads the optional '<env>.vars' map of values
+// that migrations refer to as {{ .Vars.name }} or ${name}.
+func varsFromYaml(f *yaml.File, env string) (map[string]string, error) {
+
+	vars := make(map[string]string)
+
+	node, err := yaml.Child(f.Root, fmt.Sprintf("%s.vars", env))
+	if _, ok := err.(*yaml.NodeNotFound); ok || (err == nil && node == nil) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := node.(yaml.Map)
+	if !ok {
+		return nil, fmt.Errorf("%s.vars: expected a map of names to values", env)
+	}
+
+	for name, v := range m {
+		value, ok := v.(yaml.Scalar)
+		if !ok {
+			return nil, fmt.Errorf("%s.vars.%s: expected a value", env, name)
+		}
+		vars[name] = strings.TrimSpace(value.String())
+	}
+
+	return vars, nil
+}
+
+// scratchFromYaml reads the optional '<env>.scratch' database, e.g.
+//
+//	scratch:
+//	    driver: sqlite3
+//	    open: /tmp/eioh-scratch.db
+//
+// The driver defaults to the one of the environment.
+func scratchFromYaml(f *yaml.File, env, drv string) (DBDriver, error) {
+
+	open, err := f.Get(fmt.Sprintf("%s.scratch.open", env))
+	if err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); ok {
+			return DBDriver{}, nil
+		}
+		return DBDriver{}, err
+	}
+
+	if d, err := f.Get(fmt.Sprintf("%s.scratch.driver", env)); err == nil {
+		drv = d
+	} else if _, ok := err.(*yaml.NodeNotFound); !ok {
+		return DBDriver{}, err
+	}
+
+	return newDBDriver(os.ExpandEnv(drv), os.ExpandEnv(open)), nil
+}
+
 func newDBDriver(name, open string) DBDriver {
 
 	d := DBDriver{
@@ -70,6 +271,10 @@ func newDBDriver(name, open string) DBDriver {
 
 	case "mysql":
 		d.Base = &MySqlBase{}
+	case "postgres":
+		d.Base = &PostgresBase{}
+	case "sqlite3":
+		d.Base = &Sqlite3Base{}
 	}
 	return d
 }
@@ -80,4 +285,4 @@ func OpenDBFromDBConf(conf *DBConf) (*sql.DB, error) {
 		return nil, err
 	}
 	return db, nil
-}
\ No newline at end of file
+}
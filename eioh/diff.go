@@ -0,0 +1,435 @@
+package eioh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// 目標のスキーマファイルと現在のDBを比較し、差分を埋めるマイグレーションを生成する
+
+// Column is a column of a table, as introspected from the database.
+type Column struct {
+	Table   string
+	Name    string
+	Type    string
+	NotNull bool
+	Default sql.NullString // as an SQL expression
+	Extra   string         // such as mysql's auto_increment
+}
+
+// Index is a secondary index, with the DDL that creates it.
+type Index struct {
+	Table string
+	Name  string
+	DDL   string
+}
+
+// Constraint is a primary key, foreign key, unique or check constraint.
+type Constraint struct {
+	Table string
+	Name  string
+	Def   string
+}
+
+func scanColumns(rows *sql.Rows, err error) ([]Column, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []Column
+	for rows.Next() {
+		var c Column
+		if err = rows.Scan(&c.Table, &c.Name, &c.Type, &c.NotNull, &c.Default, &c.Extra); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+func scanIndexes(rows *sql.Rows, err error) ([]Index, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var idxs []Index
+	for rows.Next() {
+		var idx Index
+		if err = rows.Scan(&idx.Table, &idx.Name, &idx.DDL); err != nil {
+			return nil, err
+		}
+		idxs = append(idxs, idx)
+	}
+	return idxs, rows.Err()
+}
+
+func scanConstraints(rows *sql.Rows, err error) ([]Constraint, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cs []Constraint
+	for rows.Next() {
+		var c Constraint
+		if err = rows.Scan(&c.Table, &c.Name, &c.Def); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+// columnDefSql is the column definition used by ADD COLUMN and the like.
+func columnDefSql(c Column) string {
+	def := c.Name + " " + c.Type
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	if c.Default.Valid {
+		def += " DEFAULT " + c.Default.String
+	}
+	if c.Extra != "" {
+		def += " " + c.Extra
+	}
+	return def
+}
+
+// dbSchema is what DiffSchema compares: the tables' columns, their
+// indexes and constraints, and the DDL of every object.
+type dbSchema struct {
+	tables      []string
+	columns     map[string][]Column
+	indexes     map[string]Index      // by indexKey
+	constraints map[string]Constraint // by indexKey
+	objects     []SchemaObject
+}
+
+// indexKey identifies an index; mysql index names are only unique per table.
+func indexKey(table, name string) string {
+	return table + "." + name
+}
+
+func introspect(conf *DBConf, db *sql.DB) (*dbSchema, error) {
+
+	s := &dbSchema{columns: make(map[string][]Column), indexes: make(map[string]Index),
+		constraints: make(map[string]Constraint)}
+
+	cols, err := conf.Driver.Base.tableColumns(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cols {
+		if eiohTables[strings.ToLower(c.Table)] {
+			continue
+		}
+		if _, ok := s.columns[c.Table]; !ok {
+			s.tables = append(s.tables, c.Table)
+		}
+		s.columns[c.Table] = append(s.columns[c.Table], c)
+	}
+
+	idxs, err := conf.Driver.Base.tableIndexes(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		if !eiohTables[strings.ToLower(idx.Table)] {
+			s.indexes[indexKey(idx.Table, idx.Name)] = idx
+		}
+	}
+
+	cs, err := conf.Driver.Base.tableConstraints(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cs {
+		if !eiohTables[strings.ToLower(c.Table)] {
+			s.constraints[indexKey(c.Table, c.Name)] = c
+		}
+	}
+
+	if s.objects, err = schemaSnapshot(conf, db); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// createTableSql returns the statements creating table t as it is in s,
+// with the sequences its columns use and its indexes (which mysql has
+// inline in the CREATE TABLE already).
+func (s *dbSchema) createTableSql(t string) (create, drop []string) {
+
+	var sequences, table, indexes, others []string
+	for _, o := range s.objects {
+		if o.Table != t {
+			continue
+		}
+		_, isIndex := s.indexes[indexKey(o.Table, o.Name)]
+		switch {
+		case o.Name == t:
+			table = append(table, o.DDL)
+		case isIndex:
+			indexes = append(indexes, o.DDL)
+		case strings.HasPrefix(o.DDL, "CREATE SEQUENCE"):
+			sequences = append(sequences, o.DDL)
+			drop = append(drop, "DROP SEQUENCE "+o.Name)
+		default:
+			// triggers and such, dropped together with the table
+			others = append(others, o.DDL)
+		}
+	}
+
+	create = append(append(append(sequences, table...), indexes...), others...)
+	drop = append([]string{"DROP TABLE " + t}, drop...)
+	return create, drop
+}
+
+func sortedIndexKeys(idxs map[string]Index) []string {
+	keys := make([]string, 0, len(idxs))
+	for k := range idxs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func findColumn(cols []Column, name string) (Column, bool) {
+	for _, c := range cols {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// UnsupportedDiffError lists differences that diff does not write statements for.
+type UnsupportedDiffError struct {
+	Missing []string // in the desired schema, not in the database
+	Extra   []string // in the database, not in the desired schema
+}
+
+func (e *UnsupportedDiffError) Error() string {
+	lines := []string{"constraints of existing tables differ, diff cannot change them, write the migration by hand:"}
+	for _, l := range e.Missing {
+		lines = append(lines, "\t+ "+l)
+	}
+	for _, l := range e.Extra {
+		lines = append(lines, "\t- "+l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diffConstraints compares the constraints of the tables that exist in both
+// cur and want. Those of created and dropped tables are in their DDL.
+func diffConstraints(cur, want *dbSchema) error {
+
+	e := &UnsupportedDiffError{}
+	for _, k := range sortedConstraintKeys(want.constraints) {
+		c := want.constraints[k]
+		if _, exists := cur.columns[c.Table]; !exists {
+			continue
+		}
+		if o, ok := cur.constraints[k]; !ok || o.Def != c.Def {
+			e.Missing = append(e.Missing, c.Table+": "+c.Def)
+		}
+	}
+	for _, k := range sortedConstraintKeys(cur.constraints) {
+		c := cur.constraints[k]
+		if _, kept := want.columns[c.Table]; !kept {
+			continue
+		}
+		if w, ok := want.constraints[k]; !ok || w.Def != c.Def {
+			e.Extra = append(e.Extra, c.Table+": "+c.Def)
+		}
+	}
+
+	if len(e.Missing)+len(e.Extra) > 0 {
+		return e
+	}
+	return nil
+}
+
+func sortedConstraintKeys(cs map[string]Constraint) []string {
+	keys := make([]string, 0, len(cs))
+	for k := range cs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// schemaChange is one step of a generated migration and its inverse.
+type schemaChange struct {
+	up, down []string
+}
+
+// diffSchemas returns the changes that turn cur into want, in the order to apply them.
+func diffSchemas(base SqlBase, cur, want *dbSchema) ([]schemaChange, error) {
+
+	if err := diffConstraints(cur, want); err != nil {
+		return nil, err
+	}
+
+	// each phase is applied after the previous one, and undone before it
+	var dropIndexes, createTables, addColumns, alterColumns, dropColumns, dropTables, createIndexes []schemaChange
+
+	// the indexes of created and dropped tables come and go with the table
+	for _, k := range sortedIndexKeys(cur.indexes) {
+		idx := cur.indexes[k]
+		if _, kept := want.columns[idx.Table]; !kept {
+			continue
+		}
+		if w, ok := want.indexes[k]; !ok || w.DDL != idx.DDL {
+			dropIndexes = append(dropIndexes, schemaChange{[]string{base.dropIndexSql(idx)}, []string{idx.DDL}})
+		}
+	}
+	for _, k := range sortedIndexKeys(want.indexes) {
+		idx := want.indexes[k]
+		if _, exists := cur.columns[idx.Table]; !exists {
+			continue
+		}
+		if c, ok := cur.indexes[k]; !ok || c.DDL != idx.DDL {
+			createIndexes = append(createIndexes, schemaChange{[]string{idx.DDL}, []string{base.dropIndexSql(idx)}})
+		}
+	}
+
+	for _, t := range want.tables {
+		curCols, ok := cur.columns[t]
+		if !ok {
+			create, drop := want.createTableSql(t)
+			createTables = append(createTables, schemaChange{create, drop})
+			continue
+		}
+
+		for _, w := range want.columns[t] {
+			c, ok := findColumn(curCols, w.Name)
+			switch {
+			case !ok:
+				addColumns = append(addColumns, schemaChange{
+					[]string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", t, columnDefSql(w))},
+					[]string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", t, w.Name)},
+				})
+			case c.Type != w.Type || c.NotNull != w.NotNull || c.Default != w.Default || c.Extra != w.Extra:
+				up, err := base.alterColumnSql(t, c, w)
+				if err != nil {
+					return nil, err
+				}
+				down, err := base.alterColumnSql(t, w, c)
+				if err != nil {
+					return nil, err
+				}
+				alterColumns = append(alterColumns, schemaChange{up, down})
+			}
+		}
+
+		for _, c := range curCols {
+			if _, ok := findColumn(want.columns[t], c.Name); !ok {
+				dropColumns = append(dropColumns, schemaChange{
+					[]string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", t, c.Name)},
+					[]string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", t, columnDefSql(c))},
+				})
+			}
+		}
+	}
+
+	for _, t := range cur.tables {
+		if _, ok := want.columns[t]; !ok {
+			create, drop := cur.createTableSql(t)
+			dropTables = append(dropTables, schemaChange{drop, create})
+		}
+	}
+
+	var changes []schemaChange
+	for _, phase := range [][]schemaChange{dropIndexes, createTables, addColumns, alterColumns, dropColumns, dropTables, createIndexes} {
+		changes = append(changes, phase...)
+	}
+	return changes, nil
+}
+
+// DiffSchema loads the desired schema in file into a temporary database,
+// on the scratch server when there is one, and returns the up and
+// (best-effort) down statements that take the environment's database
+// to it. Views are not compared, and differing constraints of existing
+// tables are reported as an UnsupportedDiffError.
+func DiffSchema(conf *DBConf, file string) (up, down []string, err error) {
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lex := newSQLLexer(conf.Driver.Name)
+	lines := strings.Split(string(b), "\n")
+	for n, line := range lines {
+		lex.feed(n+1, line, true)
+	}
+	if !lex.inCode() {
+		return nil, nil, &ValidationProblem{file, len(lines), "unterminated string, quoted identifier or comment at end of file"}
+	}
+	if rest := lex.pending(); rest != "" {
+		return nil, nil, &ValidationProblem{file, lex.start, fmt.Sprintf("unfinished SQL statement: %s. Missing a semicolon?", rest)}
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	cur, err := introspect(conf, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// keep the temporary database off the environment's own server if possible
+	host, hostDB := conf, db
+	if conf.Scratch.Name != "" {
+		if conf.Scratch.Name != conf.Driver.Name {
+			return nil, nil, errors.New(fmt.Sprintf("the scratch database of '%s' is %s, diff needs %s",
+				conf.Env, conf.Scratch.Name, conf.Driver.Name))
+		}
+		scratch := *conf
+		scratch.Driver = conf.Scratch
+		sdb, err := OpenDBFromDBConf(&scratch)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer sdb.Close()
+		host, hostDB = &scratch, sdb
+	} else if conf.Protected {
+		return nil, nil, errors.New(fmt.Sprintf("'%s' is protected, set %s.scratch.open in conf.yml "+
+			"so that diff does not create databases on its server", conf.Env, conf.Env))
+	}
+
+	var want *dbSchema
+	err = withTempDatabase(host, hostDB, func(tconf *DBConf, tdb *sql.DB) error {
+		for _, s := range lex.stmts {
+			if _, err := tdb.Exec(s.SQL); err != nil {
+				return errors.New(fmt.Sprintf("%s:%d: %v", file, s.Line, err))
+			}
+		}
+		var err error
+		want, err = introspect(tconf, tdb)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := diffSchemas(conf.Driver.Base, cur, want)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range changes {
+		up = append(up, changes[i].up...)
+		down = append(down, changes[len(changes)-1-i].down...)
+	}
+	return up, down, nil
+}
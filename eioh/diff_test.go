@@ -0,0 +1,47 @@
+package eioh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSqliteChecks(t *testing.T) {
+
+	tests := []struct {
+		name string
+		ddl  string
+		want []string
+	}{
+		{
+			name: "no checks",
+			ddl:  "CREATE TABLE a (id int PRIMARY KEY)",
+		},
+		{
+			name: "column and table checks",
+			ddl:  "CREATE TABLE a (n int CHECK (n > 0), m int,\n    check(m < n))",
+			want: []string{"CHECK (n > 0)", "check(m < n)"},
+		},
+		{
+			name: "nested parentheses",
+			ddl:  "CREATE TABLE a (s text, CHECK (length(trim(s)) > 0))",
+			want: []string{"CHECK (length(trim(s)) > 0)"},
+		},
+		{
+			name: "parentheses and keywords in strings",
+			ddl:  "CREATE TABLE a (s text DEFAULT 'CHECK (', CHECK (s <> ')'))",
+			want: []string{"CHECK (s <> ')')"},
+		},
+		{
+			name: "identifiers containing check",
+			ddl:  `CREATE TABLE a (checked int, "check" int, recheck int)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqliteChecks(tt.ddl); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
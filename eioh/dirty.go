@@ -0,0 +1,139 @@
+package eioh
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// マイグレーション途中で失敗した場合のdirty状態の管理
+
+// DirtyError is returned when a previous migration started but never finished.
+type DirtyError struct {
+	Version   int64
+	Direction bool
+	Since     time.Time
+}
+
+func (e *DirtyError) Error() string {
+	d := "up"
+	if !e.Direction {
+		d = "down"
+	}
+	return fmt.Sprintf("database is dirty: migration %d (%s) started at %v and did not finish. "+
+		"fix the schema by hand, then run 'eioh force <version>'", e.Version, d, e.Since)
+}
+
+func ensureDirtyTable(conf *DBConf, db *sql.DB) error {
+
+	rows, err := conf.Driver.Base.dirtyQuery(db)
+	if err == nil {
+		return rows.Close()
+	}
+	if err != ErrTableDoesNotExist {
+		return err
+	}
+
+	_, err = db.Exec(conf.Driver.Base.createDirtyTableSql())
+	return err
+}
+
+// dirtyVersion returns the marker left by an unfinished migration, or nil.
+func dirtyVersion(conf *DBConf, db *sql.DB) (*MigrationRecord, error) {
+
+	if err := ensureDirtyTable(conf, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := conf.Driver.Base.dirtyQuery(db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var row MigrationRecord
+	if err = rows.Scan(&row.VersionId, &row.Status, &row.CreateDate); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func checkDirty(conf *DBConf, db *sql.DB) error {
+
+	row, err := dirtyVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	if row != nil {
+		return &DirtyError{row.VersionId, row.Status, row.CreateDate}
+	}
+	return nil
+}
+
+// markDirty is written outside the migration's transaction, so that it
+// survives statements (such as MySQL DDL) that commit implicitly.
+func markDirty(conf *DBConf, db *sql.DB, v int64, direction bool) error {
+	_, err := db.Exec(conf.Driver.Base.insertDirtySql(), v, direction)
+	return err
+}
+
+func clearDirty(conf *DBConf, db *sql.DB, v int64) error {
+	_, err := db.Exec(conf.Driver.Base.deleteDirtySql(), v)
+	return err
+}
+
+// ForceVersion clears any dirty marker and records v as the current version,
+// after an operator has repaired a half-applied migration by hand.
+// Versions above v are recorded as rolled back.
+func ForceVersion(conf *DBConf, v int64) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err = EnsureDBVersion(conf, db); err != nil {
+		return err
+	}
+
+	applied, err := AppliedVersions(conf, db)
+	if err != nil {
+		return err
+	}
+
+	row, err := dirtyVersion(conf, db)
+	if err != nil {
+		return err
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if row != nil {
+		if _, err = txn.Exec(conf.Driver.Base.deleteDirtySql(), row.VersionId); err != nil {
+			txn.Rollback()
+			return err
+		}
+		log.Printf("eioh: cleared dirty marker for version %d\n", row.VersionId)
+	}
+
+	// anything above v is, by the operator's word, not applied
+	for a := range applied {
+		if a > v {
+			if _, err = txn.Exec(conf.Driver.Base.insertVersionSql(), a, false); err != nil {
+				txn.Rollback()
+				return err
+			}
+		}
+	}
+
+	return FinalizeMigration(conf, txn, true, v)
+}
@@ -0,0 +1,176 @@
+package eioh
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// 適用時のロールバックSQLを履歴テーブルに保存し、ファイルがなくてもdownできるようにする
+
+// kinds of history rows
+const (
+	historyApplied  = "applied"
+	historyBaseline = "baseline"
+	historyManual   = "manual"
+	historySkipped  = "skipped" // limited to other environments with '-- +eioh env'
+)
+
+// storedRollback is the down section of a migration, saved in
+// db_version_history when the migration is applied.
+type storedRollback struct {
+	Statements []Statement
+	Settings   []Setting
+}
+
+func ensureHistoryTable(conf *DBConf, db *sql.DB) error {
+
+	rows, err := conf.Driver.Base.historyQuery(db)
+	if err == nil {
+		return rows.Close()
+	}
+	if err != ErrTableDoesNotExist {
+		return err
+	}
+
+	_, err = db.Exec(conf.Driver.Base.createHistoryTableSql())
+	return err
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationChecksum is the checksum of the migration in path, as rendered for conf.
+func migrationChecksum(conf *DBConf, path string) (string, error) {
+	text, err := readMigration(conf, path)
+	if err != nil {
+		return "", err
+	}
+	return checksum(text.SQL), nil
+}
+
+// recordHistory writes the history row for m inside txn, linked
+// to the db_version row versionId written in the same transaction.
+func recordHistory(conf *DBConf, txn *sql.Tx, m *preparedMigration, versionId int64) error {
+
+	var downSQL interface{}
+	if m.Rollback != nil {
+		b, err := json.Marshal(m.Rollback)
+		if err != nil {
+			return err
+		}
+		downSQL = string(b)
+	}
+
+	kind := m.Kind
+	if kind == "" {
+		kind = historyApplied
+	}
+
+	_, err := txn.Exec(conf.Driver.Base.insertHistorySql(),
+		m.Version, m.Direction, filepath.Base(m.Source), m.Checksum, downSQL, kind, m.Operator, m.Batch, versionId)
+	return err
+}
+
+// recordVersion writes the db_version row for m and its history row inside txn.
+func recordVersion(conf *DBConf, txn *sql.Tx, m *preparedMigration) error {
+	id, err := conf.Driver.Base.insertVersion(txn, m.Version, m.Direction)
+	if err != nil {
+		return err
+	}
+	return recordHistory(conf, txn, m, id)
+}
+
+// historyNote is the status note for a db_version row whose history row
+// has kind and operator. Rows written by force, or before the history
+// table existed, have no history row and get no note.
+func historyNote(kind, operator string) string {
+
+	note := ""
+	if kind != "" && kind != historyApplied {
+		note = kind
+	}
+	if operator != "" {
+		note = strings.TrimSpace(note + " by " + operator)
+	}
+	return note
+}
+
+// RollbackFromDB rolls back the current version with the down section stored
+// when it was applied, for when its file is missing or has changed since.
+func RollbackFromDB(conf *DBConf) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := EnsureDBVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return ErrNoPreviousVersion
+	}
+
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	return rollbackFromHistory(conf, db, current, 0)
+}
+
+// rollbackFromHistory rolls back v with the down section stored in the history.
+func rollbackFromHistory(conf *DBConf, db *sql.DB, v int64, batch int64) error {
+
+	var source, sum string
+	var downSQL sql.NullString
+	err := conf.Driver.Base.rollbackQuery(db, v).Scan(&source, &sum, &downSQL)
+	if err == sql.ErrNoRows || (err == nil && !downSQL.Valid) {
+		return errors.New(fmt.Sprintf("no rollback SQL stored for version %d", v))
+	}
+	if err != nil {
+		return err
+	}
+
+	var down storedRollback
+	if err = json.Unmarshal([]byte(downSQL.String), &down); err != nil {
+		return err
+	}
+
+	path := filepath.Join(conf.MigrationsDir, source)
+	if s, err := migrationChecksum(conf, path); err != nil {
+		fmt.Printf("eioh: %s is missing\n", path)
+	} else if s != sum {
+		fmt.Printf("eioh: %s has changed since it was applied\n", path)
+	}
+
+	fmt.Printf("eioh: rolling back version %d with the SQL stored when it was applied\n", v)
+
+	m := &preparedMigration{
+		Source:     path,
+		Version:    v,
+		Direction:  false,
+		Statements: down.Statements,
+		Settings:   down.Settings,
+		Checksum:   sum,
+		Batch:      batch,
+	}
+	if err = execSQLMigration(conf, db, m); err != nil {
+		return err
+	}
+
+	fmt.Println("OK   ", source)
+	return nil
+}
@@ -0,0 +1,178 @@
+package eioh
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// 適用前にALTER/UPDATE対象テーブルのサイズを調べて影響を見積もる
+
+var (
+	alterTargetRe = regexp.MustCompile(`(?i)^(ALTER TABLE|UPDATE)( ONLY| IF EXISTS| LOW_PRIORITY| IGNORE)* ([\w.$]+)`)
+	indexTargetRe = regexp.MustCompile(`(?i)^CREATE (UNIQUE )?INDEX\b.*?\bON( ONLY)? ([\w.$]+)`)
+)
+
+// TableImpact is a statement in a pending migration that touches an existing table.
+type TableImpact struct {
+	Source    string
+	Line      int
+	Operation string
+	Schema    string
+	Table     string
+	Rows      int64
+	Size      int64
+	Found     bool
+	Rewrite   bool
+}
+
+// statementTarget returns the operation and table altered or updated by stmt.
+func statementTarget(dialect, stmt string) (op, table string) {
+	s := stripSQL(dialect, stmt)
+	if m := alterTargetRe.FindStringSubmatch(s); m != nil {
+		return strings.ToUpper(m[1]), m[3]
+	}
+	if m := indexTargetRe.FindStringSubmatch(s); m != nil {
+		return "CREATE INDEX", m[3]
+	}
+	return "", ""
+}
+
+// EstimateImpact looks up the size of every table the migrations ms alter or update.
+func EstimateImpact(conf *DBConf, db *sql.DB, ms []*Migration) ([]*TableImpact, error) {
+
+	var impacts []*TableImpact
+
+	for _, m := range ms {
+
+		text, err := readMigration(conf, m.Source)
+		if err != nil {
+			return nil, err
+		}
+		script, err := scanMigration(text, true, conf.Driver.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !runsIn(script.Envs, conf.Env) {
+			continue
+		}
+
+		for _, s := range script.Statements {
+			op, table := statementTarget(conf.Driver.Name, s.SQL)
+			if table == "" {
+				continue
+			}
+
+			ti := &TableImpact{Source: s.file(m.Source), Line: s.Line, Operation: op, Table: table}
+			if i := strings.LastIndex(table, "."); i >= 0 {
+				ti.Schema, ti.Table = table[:i], table[i+1:]
+			}
+			ti.Rewrite = checkTableRewrite(conf.Driver.Name, normalizeSQL(conf.Driver.Name, s.SQL)) != ""
+
+			ti.Rows, ti.Size, err = conf.Driver.Base.tableSize(db, ti.Schema, ti.Table)
+			switch err {
+			case nil:
+				ti.Found = true
+			case sql.ErrNoRows:
+				// created by an earlier statement in the plan
+			default:
+				return nil, err
+			}
+
+			impacts = append(impacts, ti)
+		}
+	}
+
+	return impacts, nil
+}
+
+// pendingSetting is a '-- +eioh set' setting of a pending migration.
+type pendingSetting struct {
+	Source string
+	Setting
+}
+
+// pendingSettings returns the settings the migrations ms apply on the way up.
+func pendingSettings(conf *DBConf, ms []*Migration) ([]pendingSetting, error) {
+
+	var settings []pendingSetting
+
+	for _, m := range ms {
+		text, err := readMigration(conf, m.Source)
+		if err != nil {
+			return nil, err
+		}
+		script, err := scanMigration(text, true, conf.Driver.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !runsIn(script.Envs, conf.Env) {
+			continue
+		}
+		for _, st := range script.Settings {
+			ps := pendingSetting{Setting: st}
+			ps.Source, ps.Line = text.locate(st.Line)
+			settings = append(settings, ps)
+		}
+	}
+
+	return settings, nil
+}
+
+func printImpactReport(conf *DBConf, db *sql.DB, ms []*Migration) error {
+
+	impacts, err := EstimateImpact(conf, db, ms)
+	if err != nil {
+		return err
+	}
+	settings, err := pendingSettings(conf, ms)
+	if err != nil || len(impacts)+len(settings) == 0 {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Migration", "Operation", "Table", "Rows", "Size", "Note"})
+
+	// applied before the statements of their migration
+	for _, st := range settings {
+		src := fmt.Sprintf("%s:%d", filepath.Base(st.Source), st.Line)
+		table.Append([]string{src, "SET", "-", "-", "-", st.Name + " = " + st.Value})
+	}
+	for _, ti := range impacts {
+		rows, size, note := "-", "-", "new table"
+		if ti.Found {
+			rows = strconv.FormatInt(ti.Rows, 10)
+			size = formatBytes(ti.Size)
+			note = ""
+			if ti.Rewrite && ti.Size >= conf.LargeTableSize {
+				note = fmt.Sprintf("LARGE TABLE REWRITE (> %s)", formatBytes(conf.LargeTableSize))
+			}
+		}
+		src := fmt.Sprintf("%s:%d", filepath.Base(ti.Source), ti.Line)
+		table.Append([]string{src, ti.Operation, ti.Table, rows, size, note})
+	}
+
+	fmt.Println("eioh: estimated impact of pending migrations")
+	table.Render()
+
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
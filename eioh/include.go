@@ -0,0 +1,152 @@
+package eioh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// -- +eioh include で共有SQLファイルを取り込む
+
+// lineOrigin is the file and line a line of an expanded migration comes from.
+type lineOrigin struct {
+	Source string
+	Line   int
+}
+
+// migrationText is a migration with its includes spliced in and its
+// variables rendered, ready to be scanned.
+type migrationText struct {
+	Path    string
+	SQL     []byte
+	origins []lineOrigin // by line of SQL, starting at 0
+}
+
+// parseDirective splits a '-- +eioh verb arg' line.
+func parseDirective(line string) (verb, arg string, ok bool) {
+	if !strings.HasPrefix(line, sqlCmdPrefix) {
+		return "", "", false
+	}
+	cmd := strings.TrimSpace(line[len(sqlCmdPrefix):])
+	verb = cmd
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		verb, arg = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+	return verb, arg, true
+}
+
+// expandIncludes returns the file path, rendered for conf, with every
+// '-- +eioh include' replaced by the (rendered and expanded) file it
+// names, relative to conf.MigrationsDir.
+// stack holds the files including path, to detect cycles.
+func expandIncludes(conf *DBConf, path string, stack []string) ([]byte, []lineOrigin, error) {
+
+	for i, p := range stack {
+		if p == path {
+			chain := append(stack[i:], path)
+			return nil, nil, &ValidationProblem{Source: stack[0],
+				Msg: "include cycle: " + strings.Join(chain, " -> ")}
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if len(stack) > 0 {
+			return nil, nil, &ValidationProblem{Source: stack[len(stack)-1], Msg: err.Error()}
+		}
+		return nil, nil, err
+	}
+	// each file is rendered on its own before it is spliced in,
+	// so that origins describe the rendered lines
+	if b, err = renderSQL(conf, path, b); err != nil {
+		return nil, nil, err
+	}
+	stack = append(stack, path)
+
+	var out bytes.Buffer
+	var origins []lineOrigin
+
+	// an included file is inside the section of the file including it
+	inSection := len(stack) > 1
+
+	for n, line := range strings.SplitAfter(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+
+		verb, arg, ok := parseDirective(strings.TrimRight(line, "\r\n"))
+		switch {
+		case ok && (verb == "up" || verb == "down") && len(stack) > 1:
+			return nil, nil, &ValidationProblem{path, n + 1, "an included file cannot have up or down sections"}
+
+		case ok && (verb == "up" || verb == "down"):
+			inSection = true
+
+		case ok && verb == "include":
+			if !inSection {
+				return nil, nil, &ValidationProblem{path, n + 1, "'-- +eioh include' must be inside an up or down section"}
+			}
+			if arg == "" {
+				return nil, nil, &ValidationProblem{path, n + 1, "'-- +eioh include' expects a file name"}
+			}
+			inc := arg
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(conf.MigrationsDir, inc)
+			}
+			ib, incOrigins, err := expandIncludes(conf, inc, stack)
+			if err != nil {
+				return nil, nil, err
+			}
+			out.Write(ib)
+			origins = append(origins, incOrigins...)
+			continue
+		}
+
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			// so that the next line of the including file starts on its own
+			out.WriteByte('\n')
+		}
+		origins = append(origins, lineOrigin{path, n + 1})
+	}
+
+	return out.Bytes(), origins, nil
+}
+
+// locate returns the file and line that line n of t comes from.
+func (t *migrationText) locate(n int) (string, int) {
+	if n < 1 || n > len(t.origins) {
+		return t.Path, n
+	}
+	o := t.origins[n-1]
+	return o.Source, o.Line
+}
+
+// scanMigration scans t like scanSQLScript, with the lines of
+// statements and problems pointing into the files they came from.
+func scanMigration(t *migrationText, direction bool, dialect string) (*sqlScript, error) {
+
+	script, err := scanSQLScript(bytes.NewReader(t.SQL), direction, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range script.Statements {
+		s := &script.Statements[i]
+		source, line := t.locate(s.Line)
+		if source != t.Path {
+			s.Source = source
+		}
+		s.Line = line
+	}
+	for _, p := range script.Problems {
+		source, line := t.locate(p.Line)
+		if source != t.Path {
+			p.Source = source
+		}
+		p.Line = line
+	}
+
+	return script, nil
+}
@@ -0,0 +1,121 @@
+package eioh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "eioh-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"m.sql": "-- +eioh up\nSELECT 1;\n-- +eioh include shared/a.sql\nSELECT 4;\n",
+		"shared/a.sql": "SELECT 2;\n-- +eioh include shared/b.sql\n" +
+			"SELECT 3;",
+		"shared/b.sql":    "SELECT '{{ .Vars.x }}', {{ .Vars.x }};\n",
+		"cycle.sql":       "-- +eioh up\n-- +eioh include shared/c1.sql\n",
+		"shared/c1.sql":   "-- +eioh include shared/c2.sql\n",
+		"shared/c2.sql":   "-- +eioh include shared/c1.sql\n",
+		"missing.sql":     "-- +eioh up\n-- +eioh include shared/a.sql\n-- +eioh include shared/nope.sql\n",
+		"outside.sql":     "-- +eioh include shared/b.sql\n-- +eioh up\n",
+		"sections.sql":    "-- +eioh up\n-- +eioh include shared/down.sql\n",
+		"shared/down.sql": "SELECT 1;\n-- +eioh down\n",
+	}
+	for name, src := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	conf := &DBConf{Env: "development", MigrationsDir: dir, Driver: DBDriver{Name: "postgres"},
+		Vars: map[string]string{"x": "v"}}
+
+	t.Run("nested", func(t *testing.T) {
+		b, origins, err := expandIncludes(conf, path("m.sql"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "-- +eioh up\nSELECT 1;\nSELECT 2;\nSELECT '{{ .Vars.x }}', v;\nSELECT 3;\nSELECT 4;\n"
+		if string(b) != want {
+			t.Errorf("got\n%s\nwant\n%s", b, want)
+		}
+		wantOrigins := []lineOrigin{
+			{path("m.sql"), 1},
+			{path("m.sql"), 2},
+			{path("shared/a.sql"), 1},
+			{path("shared/b.sql"), 1},
+			{path("shared/a.sql"), 3},
+			{path("m.sql"), 4},
+		}
+		if !reflect.DeepEqual(origins, wantOrigins) {
+			t.Errorf("origins:\n got %v\nwant %v", origins, wantOrigins)
+		}
+
+		text := &migrationText{path("m.sql"), b, origins}
+		if source, line := text.locate(5); source != path("shared/a.sql") || line != 3 {
+			t.Errorf("locate(5) = %s:%d, want %s:3", source, line, path("shared/a.sql"))
+		}
+	})
+
+	problems := []struct {
+		name   string
+		file   string
+		source string
+		line   int
+		msg    string
+	}{
+		{
+			name:   "cycle",
+			file:   "cycle.sql",
+			source: "cycle.sql",
+			msg:    "include cycle: " + path("shared/c1.sql") + " -> " + path("shared/c2.sql") + " -> " + path("shared/c1.sql"),
+		},
+		{
+			name:   "missing file",
+			file:   "missing.sql",
+			source: "missing.sql",
+			msg:    "nope.sql",
+		},
+		{
+			name:   "include outside a section",
+			file:   "outside.sql",
+			source: "outside.sql",
+			line:   1,
+			msg:    "must be inside an up or down section",
+		},
+		{
+			name:   "sections in an included file",
+			file:   "sections.sql",
+			source: "shared/down.sql",
+			line:   2,
+			msg:    "cannot have up or down sections",
+		},
+	}
+
+	for _, tt := range problems {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := expandIncludes(conf, path(tt.file), nil)
+			p, ok := err.(*ValidationProblem)
+			if !ok {
+				t.Fatalf("got %v, want a problem in %s", err, tt.source)
+			}
+			if p.Source != path(tt.source) || p.Line != tt.line || !strings.Contains(p.Msg, tt.msg) {
+				t.Errorf("got %v, want %s:%d containing %q", p, path(tt.source), tt.line, tt.msg)
+			}
+		})
+	}
+}
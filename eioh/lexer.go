@@ -0,0 +1,281 @@
+package eioh
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Statement is a single SQL statement read from a migration file.
+type Statement struct {
+	SQL    string
+	Line   int    // line on which the statement starts
+	Source string `json:",omitempty"` // the included file it was read from, if any
+}
+
+// file returns the file s was read from, migration unless it was included.
+func (s Statement) file(migration string) string {
+	if s.Source != "" {
+		return s.Source
+	}
+	return migration
+}
+
+// sqlLexer splits SQL text fed to it line by line into statements.
+// It knows enough about each dialect's quoting and comment forms
+// to not mistake a semicolon inside them for a terminator.
+type sqlLexer struct {
+	dialect   string
+	delimiter string // statement terminator, changed by mysql's DELIMITER
+
+	quote     byte   // closing quote while inside a string or quoted identifier
+	escapes   bool   // backslash escapes apply inside the current string
+	dollarTag string // closing tag while inside a postgres dollar-quoted body
+	comment   int    // nesting depth of /* */ comments
+
+	buf     bytes.Buffer
+	hasCode bool // buf holds something other than whitespace and comments
+	codeAt  int  // offset in buf where the statement's code begins
+	start   int  // line on which the statement's code begins
+
+	stmts []Statement
+
+	// when set, code[i] tells whether byte i of the line fed last is code
+	// or a quoted identifier, rather than part of a literal or comment
+	trackCode bool
+	code      []bool
+}
+
+func newSQLLexer(dialect string) *sqlLexer {
+	return &sqlLexer{dialect: dialect, delimiter: ";"}
+}
+
+// inCode reports whether the lexer is outside of any string or comment.
+func (l *sqlLexer) inCode() bool {
+	return l.quote == 0 && l.dollarTag == "" && l.comment == 0
+}
+
+// inIdentifier reports whether the lexer is inside a quoted identifier.
+func (l *sqlLexer) inIdentifier() bool {
+	return l.quote == '`' || l.quote == ']' || (l.quote == '"' && l.dialect != "mysql")
+}
+
+// pending returns the code of an unterminated statement, if any.
+func (l *sqlLexer) pending() string {
+	if !l.hasCode {
+		return ""
+	}
+	return strings.TrimSpace(l.buf.String()[l.codeAt:])
+}
+
+// feed scans line n. When split is false, terminators are ignored
+// and the statement only ends on flush.
+func (l *sqlLexer) feed(n int, line string, split bool) {
+
+	if l.trackCode {
+		l.code = make([]bool, len(line))
+	}
+
+	if split && l.setDelimiter(line) {
+		return
+	}
+
+	from := 0 // offset in line from which text is still to be buffered
+
+	mark := func(i int) {
+		if !l.hasCode {
+			l.hasCode = true
+			l.codeAt = l.buf.Len() + i - from
+			l.start = n
+		}
+	}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		if l.trackCode {
+			l.code[i] = l.inCode() || l.inIdentifier()
+		}
+
+		switch {
+		case l.comment > 0:
+			if strings.HasPrefix(line[i:], "*/") {
+				l.comment--
+				i += 2
+			} else if l.dialect == "postgres" && strings.HasPrefix(line[i:], "/*") {
+				l.comment++
+				i += 2
+			} else {
+				i++
+			}
+
+		case l.dollarTag != "":
+			if strings.HasPrefix(line[i:], l.dollarTag) {
+				i += len(l.dollarTag)
+				l.dollarTag = ""
+			} else {
+				i++
+			}
+
+		case l.quote != 0:
+			if l.escapes && c == '\\' {
+				i += 2
+				break
+			}
+			if c == l.quote {
+				// a doubled quote is an escaped quote, not the end of the string
+				if i+1 < len(line) && line[i+1] == l.quote {
+					i += 2
+					break
+				}
+				l.quote = 0
+			}
+			i++
+
+		case l.isLineComment(line[i:]):
+			i = len(line)
+
+		case strings.HasPrefix(line[i:], "/*"):
+			// mysql executes /*! ... */ and /*+ ... */ comments
+			if l.dialect == "mysql" && i+2 < len(line) && (line[i+2] == '!' || line[i+2] == '+') {
+				mark(i)
+			}
+			l.comment = 1
+			i += 2
+
+		case split && strings.HasPrefix(line[i:], l.delimiter):
+			end := i + len(l.delimiter)
+			if l.hasCode {
+				// a custom delimiter is only a marker for the client, not part of the statement
+				if l.delimiter == ";" {
+					i = end
+				}
+				l.buf.WriteString(line[from:i])
+				l.emit()
+				from = end
+			}
+			i = end
+
+		case c == '\'':
+			mark(i)
+			l.quote = '\''
+			l.escapes = l.dialect == "mysql" || l.isEString(line, i)
+			i++
+
+		case c == '"':
+			mark(i)
+			l.quote = '"'
+			l.escapes = l.dialect == "mysql"
+			i++
+
+		case c == '`' && (l.dialect == "mysql" || l.dialect == "sqlite3"):
+			mark(i)
+			l.quote = '`'
+			l.escapes = false
+			i++
+
+		case c == '[' && l.dialect == "sqlite3":
+			mark(i)
+			l.quote = ']'
+			l.escapes = false
+			i++
+
+		case c == '$' && l.dialect == "postgres" && (i == 0 || !isIdentByte(line[i-1])):
+			mark(i)
+			if tag := dollarTag(line[i:]); tag != "" {
+				l.dollarTag = tag
+				i += len(tag)
+			} else {
+				i++
+			}
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		default:
+			mark(i)
+			i++
+		}
+	}
+
+	l.buf.WriteString(line[from:])
+	l.buf.WriteByte('\n')
+}
+
+// setDelimiter handles a mysql client 'DELIMITER $$' line, which may only
+// appear between statements. It reports whether line was one.
+func (l *sqlLexer) setDelimiter(line string) bool {
+
+	if l.dialect != "mysql" || l.hasCode || !l.inCode() {
+		return false
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "DELIMITER") {
+		return false
+	}
+
+	l.delimiter = fields[1]
+	return true
+}
+
+// flush ends the current statement, as at '-- +eioh statementend'.
+func (l *sqlLexer) flush() {
+	if l.hasCode {
+		l.emit()
+	}
+}
+
+func (l *sqlLexer) emit() {
+	l.stmts = append(l.stmts, Statement{
+		SQL:  strings.TrimSpace(l.buf.String()[l.codeAt:]),
+		Line: l.start,
+	})
+	l.buf.Reset()
+	l.hasCode = false
+}
+
+func (l *sqlLexer) isLineComment(s string) bool {
+
+	if l.dialect == "mysql" {
+		if strings.HasPrefix(s, "#") {
+			return true
+		}
+		// mysql requires whitespace after the second dash
+		return strings.HasPrefix(s, "--") && (len(s) == 2 || s[2] == ' ' || s[2] == '\t')
+	}
+
+	return strings.HasPrefix(s, "--")
+}
+
+// isEString reports whether the quote at line[i] opens a postgres E'...' string.
+func (l *sqlLexer) isEString(line string, i int) bool {
+	if l.dialect != "postgres" || i == 0 || (line[i-1] != 'E' && line[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !isIdentByte(line[i-2])
+}
+
+// dollarTag returns the $tag$ that s starts with, or "" if it is not one.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		if c == '$' {
+			return s[:j+1]
+		}
+		if !(c == '_' || isLetter(c) || (j > 1 && isDigit(c))) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || isLetter(c) || isDigit(c)
+}
@@ -0,0 +1,295 @@
+package eioh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanSQLScriptStatements(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		dialect string
+		sql     string
+		want    []Statement
+	}{
+		{
+			name:    "plain statements",
+			dialect: "mysql",
+			sql: `-- +eioh up
+CREATE TABLE a (id int);
+
+INSERT INTO a VALUES (1);`,
+			want: []Statement{
+				{SQL: "CREATE TABLE a (id int);", Line: 2},
+				{SQL: "INSERT INTO a VALUES (1);", Line: 4},
+			},
+		},
+		{
+			name:    "statement spanning lines starts at its first code line",
+			dialect: "postgres",
+			sql: `-- +eioh up
+-- a leading comment
+
+INSERT INTO a
+VALUES (1);`,
+			want: []Statement{
+				{SQL: "INSERT INTO a\nVALUES (1);", Line: 4},
+			},
+		},
+		{
+			name:    "semicolons in strings",
+			dialect: "postgres",
+			sql: `-- +eioh up
+INSERT INTO a VALUES ('x;y', 'it''s;');`,
+			want: []Statement{
+				{SQL: "INSERT INTO a VALUES ('x;y', 'it''s;');", Line: 2},
+			},
+		},
+		{
+			name:    "mysql backslash escapes",
+			dialect: "mysql",
+			sql: `-- +eioh up
+INSERT INTO a VALUES ('x\';y', "a\";b");`,
+			want: []Statement{
+				{SQL: `INSERT INTO a VALUES ('x\';y', "a\";b");`, Line: 2},
+			},
+		},
+		{
+			name:    "postgres backslash is literal outside E-strings",
+			dialect: "postgres",
+			sql: `-- +eioh up
+INSERT INTO a VALUES ('c:\');
+INSERT INTO a VALUES (E'x\';y');`,
+			want: []Statement{
+				{SQL: `INSERT INTO a VALUES ('c:\');`, Line: 2},
+				{SQL: `INSERT INTO a VALUES (E'x\';y');`, Line: 3},
+			},
+		},
+		{
+			name:    "backtick identifiers",
+			dialect: "mysql",
+			sql: "-- +eioh up\n" +
+				"CREATE TABLE `a;b` (`c;d` int);",
+			want: []Statement{
+				{SQL: "CREATE TABLE `a;b` (`c;d` int);", Line: 2},
+			},
+		},
+		{
+			name:    "sqlite bracket identifiers",
+			dialect: "sqlite3",
+			sql: `-- +eioh up
+CREATE TABLE [a;b] (x int);`,
+			want: []Statement{
+				{SQL: "CREATE TABLE [a;b] (x int);", Line: 2},
+			},
+		},
+		{
+			name:    "block comments",
+			dialect: "mysql",
+			sql: `-- +eioh up
+/* a comment;
+   over lines */
+SELECT 1; /* trailing; */
+SELECT 2;`,
+			want: []Statement{
+				{SQL: "SELECT 1;", Line: 4},
+				{SQL: "SELECT 2;", Line: 5},
+			},
+		},
+		{
+			name:    "nested block comments in postgres",
+			dialect: "postgres",
+			sql: `-- +eioh up
+/* outer /* inner; */ still; */
+SELECT 1;`,
+			want: []Statement{
+				{SQL: "SELECT 1;", Line: 3},
+			},
+		},
+		{
+			name:    "mysql executable comments are code",
+			dialect: "mysql",
+			sql: `-- +eioh up
+/*!40101 SET NAMES utf8 */;`,
+			want: []Statement{
+				{SQL: "/*!40101 SET NAMES utf8 */;", Line: 2},
+			},
+		},
+		{
+			name:    "mysql needs a space after a line comment's dashes",
+			dialect: "mysql",
+			sql: `-- +eioh up
+SELECT 1--1;
+# hash comment;
+SELECT 2;`,
+			want: []Statement{
+				{SQL: "SELECT 1--1;", Line: 2},
+				{SQL: "SELECT 2;", Line: 4},
+			},
+		},
+		{
+			name:    "dollar quoted bodies",
+			dialect: "postgres",
+			sql: `-- +eioh up
+CREATE FUNCTION f() RETURNS int AS $$
+BEGIN
+    RETURN 1;
+END;
+$$ LANGUAGE plpgsql;
+CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;`,
+			want: []Statement{
+				{SQL: "CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n    RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;", Line: 2},
+				{SQL: "CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;", Line: 7},
+			},
+		},
+		{
+			name:    "dollar tags do not start inside identifiers",
+			dialect: "postgres",
+			sql: `-- +eioh up
+SELECT a$b$c FROM t;
+SELECT $1;`,
+			want: []Statement{
+				{SQL: "SELECT a$b$c FROM t;", Line: 2},
+				{SQL: "SELECT $1;", Line: 3},
+			},
+		},
+		{
+			name:    "mysql DELIMITER $$",
+			dialect: "mysql",
+			sql: `-- +eioh up
+DELIMITER $$
+CREATE PROCEDURE p()
+BEGIN
+    SELECT 1;
+END$$
+DELIMITER ;
+SELECT 2;`,
+			want: []Statement{
+				{SQL: "CREATE PROCEDURE p()\nBEGIN\n    SELECT 1;\nEND", Line: 3},
+				{SQL: "SELECT 2;", Line: 8},
+			},
+		},
+		{
+			name:    "mysql DELIMITER //",
+			dialect: "mysql",
+			sql: `-- +eioh up
+DELIMITER //
+CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET NEW.x = 1; //
+CREATE TRIGGER u BEFORE UPDATE ON a FOR EACH ROW SET NEW.x = 2; //
+DELIMITER ;`,
+			want: []Statement{
+				{SQL: "CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW SET NEW.x = 1;", Line: 3},
+				{SQL: "CREATE TRIGGER u BEFORE UPDATE ON a FOR EACH ROW SET NEW.x = 2;", Line: 4},
+			},
+		},
+		{
+			name:    "statementbegin and statementend",
+			dialect: "mysql",
+			sql: `-- +eioh up
+-- +eioh statementbegin
+CREATE TRIGGER t BEFORE INSERT ON a
+FOR EACH ROW BEGIN SET NEW.x = 1; END;
+-- +eioh statementend
+SELECT 1;`,
+			want: []Statement{
+				{SQL: "CREATE TRIGGER t BEFORE INSERT ON a\nFOR EACH ROW BEGIN SET NEW.x = 1; END;", Line: 3},
+				{SQL: "SELECT 1;", Line: 6},
+			},
+		},
+		{
+			name:    "only the up section",
+			dialect: "mysql",
+			sql: `-- +eioh up
+SELECT 1;
+-- +eioh down
+SELECT 2;`,
+			want: []Statement{
+				{SQL: "SELECT 1;", Line: 2},
+			},
+		},
+		{
+			name:    "dialect sections",
+			dialect: "sqlite3",
+			sql: `-- +eioh up mysql
+SELECT 1;
+-- +eioh up sqlite3,postgres
+SELECT 2;`,
+			want: []Statement{
+				{SQL: "SELECT 2;", Line: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := scanSQLScript(strings.NewReader(tt.sql), true, tt.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range script.Problems {
+				t.Errorf("unexpected problem: %v", p)
+			}
+			if !reflect.DeepEqual(script.Statements, tt.want) {
+				t.Errorf("statements:\n got %q\nwant %q", script.Statements, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanSQLScriptProblems(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		dialect string
+		sql     string
+		line    int
+		msg     string
+	}{
+		{
+			name:    "unterminated string",
+			dialect: "postgres",
+			sql:     "-- +eioh up\nSELECT 'abc;\n",
+			line:    2,
+			msg:     "unterminated string",
+		},
+		{
+			name:    "unterminated dollar body",
+			dialect: "postgres",
+			sql:     "-- +eioh up\nSELECT $$ abc;\nSELECT 1;\n",
+			line:    3,
+			msg:     "unterminated string",
+		},
+		{
+			name:    "missing semicolon",
+			dialect: "mysql",
+			sql:     "-- +eioh up\nSELECT 1;\nSELECT 2\n",
+			line:    3,
+			msg:     "Missing a semicolon?",
+		},
+		{
+			name:    "unknown annotation",
+			dialect: "mysql",
+			sql:     "-- +eioh up\n-- +eioh frob\n",
+			line:    2,
+			msg:     `unknown annotation "frob"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := scanSQLScript(strings.NewReader(tt.sql), true, tt.dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(script.Problems) == 0 {
+				t.Fatalf("got no problems, want line %d %q", tt.line, tt.msg)
+			}
+			p := script.Problems[0]
+			if p.Line != tt.line || !strings.Contains(p.Msg, tt.msg) {
+				t.Errorf("got line %d %q, want line %d containing %q", p.Line, p.Msg, tt.line, tt.msg)
+			}
+		})
+	}
+}
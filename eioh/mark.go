@@ -0,0 +1,75 @@
+package eioh
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 手作業で適用したマイグレーションを、SQLを実行せずに適用済み・未適用にする
+
+// MarkVersion records v as applied (or rolled back) without running any SQL,
+// for changes a DBA has made by hand. The history row is tagged as manual.
+func MarkVersion(conf *DBConf, v int64, applied bool, operator string) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err = EnsureDBVersion(conf, db); err != nil {
+		return err
+	}
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	versions, err := AppliedVersions(conf, db)
+	if err != nil {
+		return err
+	}
+	if versions[v] == applied {
+		if applied {
+			return errors.New(fmt.Sprintf("version %d is already applied", v))
+		}
+		return errors.New(fmt.Sprintf("version %d is not applied", v))
+	}
+
+	m := &preparedMigration{Source: fmt.Sprint(v), Version: v, Direction: applied}
+
+	files, err := CollectMigrations(conf.MigrationsDir, v-1, v)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(files) > 0 && applied:
+		// keep the down section, so that the change can be rolled back from the db
+		if m, err = prepareSQLMigration(conf, files[0].Source, v, true); err != nil {
+			return err
+		}
+	case len(files) > 0:
+		if m.Checksum, err = migrationChecksum(conf, files[0].Source); err != nil {
+			return err
+		}
+		m.Source = files[0].Source
+	case applied:
+		return errors.New(fmt.Sprintf("no migration file found for version %d", v))
+	}
+	m.Kind = historyManual
+	m.Operator = operator
+
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = recordVersion(conf, txn, m); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
@@ -2,7 +2,7 @@ package eioh
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"database/sql"
 	"io"
 	"log"
@@ -17,6 +17,8 @@ import (
 	"sort"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/olekukonko/tablewriter"
 )
 
@@ -31,99 +33,173 @@ func (ms migrationSorter) Len() int           { return len(ms) }
 func (ms migrationSorter) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
 func (ms migrationSorter) Less(i, j int) bool { return ms[i].Version < ms[j].Version }
 
-func endsWithSemicolon(line string) bool {
-
-	prev := ""
-	scanner := bufio.NewScanner(strings.NewReader(line))
-	scanner.Split(bufio.ScanWords)
-
-	for scanner.Scan() {
-		word := scanner.Text()
-		if strings.HasPrefix(word, "--") {
-			break
-		}
-		prev = word
-	}
-
-	return strings.HasSuffix(prev, ";")
+// sqlScript is one direction of a migration file, split into statements.
+type sqlScript struct {
+	Statements []Statement
+	Settings   []Setting
+	Problems   []*ValidationProblem
+	Up         int      // number of '-- +eioh up' annotations seen
+	Down       int      // number of '-- +eioh down' annotations seen
+	Envs       []string // environments from '-- +eioh env', all if empty
+	Dialects   []string // dialects that sections are tagged with
 }
 
-func splitSQLStatements(r io.Reader, direction bool) (stmts []string) {
+func scanSQLScript(r io.Reader, direction bool, dialect string) (*sqlScript, error) {
 
-	var buf bytes.Buffer
 	scanner := bufio.NewScanner(r)
+	lex := newSQLLexer(dialect)
+	script := &sqlScript{}
 
-	upSections := 0
-	downSections := 0
+	problem := func(line int, format string, args ...interface{}) {
+		script.Problems = append(script.Problems, &ValidationProblem{Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
 
-	statementEnded := false
 	ignoreSemicolons := false
 	directionIsActive := false
+	beganAt := 0
 
+	n := 0
 	for scanner.Scan() {
 
+		n++
 		line := scanner.Text()
-		if strings.HasPrefix(line, sqlCmdPrefix) {
-			cmd := strings.TrimSpace(line[len(sqlCmdPrefix):])
-			switch cmd {
+		if verb, arg, ok := parseDirective(line); ok {
+			switch verb {
 			case "up":
-				directionIsActive = (direction == true)
-				upSections++
+				directionIsActive = (direction == true) && sectionMatches(arg, dialect, n, problem)
+				script.Up++
+				script.addDialects(arg)
 				break
 
 			case "down":
-				directionIsActive = (direction == false)
-				downSections++
+				directionIsActive = (direction == false) && sectionMatches(arg, dialect, n, problem)
+				script.Down++
+				script.addDialects(arg)
+				break
+
+			case "env":
+				if script.Up+script.Down > 0 {
+					problem(n, "'-- +eioh env' must come before the first up or down section")
+					break
+				}
+				if script.Envs = splitList(arg); len(script.Envs) == 0 {
+					problem(n, "'-- +eioh env' expects a list of environments, e.g. production,staging")
+				}
 				break
 
 			case "statementbegin":
 				if directionIsActive {
 					ignoreSemicolons = true
+					beganAt = n
 				}
 				break
 
 			case "statementend":
 				if directionIsActive {
-					statementEnded = (ignoreSemicolons == true)
+					if ignoreSemicolons {
+						lex.flush()
+					} else {
+						problem(n, "saw '-- +eioh statementend' with no matching '-- +eioh statementbegin'")
+					}
 					ignoreSemicolons = false
 				}
 				break
+
+			case "set":
+				// settings before the first section apply to both directions
+				if !directionIsActive && script.Up+script.Down > 0 {
+					break
+				}
+				setting, err := parseSetting(arg)
+				if err != nil {
+					problem(n, "%v", err)
+					break
+				}
+				setting.Line = n
+				script.Settings = append(script.Settings, setting)
+
+			default:
+				problem(n, "unknown annotation %q", strings.TrimSpace(verb+" "+arg))
 			}
+			continue
 		}
 
 		if !directionIsActive {
 			continue
 		}
 
-		if _, err := buf.WriteString(line + "\n"); err != nil {
-			log.Fatalf("io err: %v", err)
-		}
-
-		if (!ignoreSemicolons && endsWithSemicolon(line)) || statementEnded {
-			statementEnded = false
-			stmts = append(stmts, buf.String())
-			buf.Reset()
-		}
+		lex.feed(n, line, !ignoreSemicolons)
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("scanning migration: %v", err)
+		return nil, err
 	}
 
 	if ignoreSemicolons {
-		log.Println("WARNING: saw '-- +eioh statementbegin' with no matching '-- +eioh statementend'")
+		problem(beganAt, "saw '-- +eioh statementbegin' with no matching '-- +eioh statementend'")
+	}
+
+	if !lex.inCode() {
+		problem(n, "unterminated string, quoted identifier or comment at end of file")
+	}
+
+	if bufferRemaining := lex.pending(); len(bufferRemaining) > 0 {
+		problem(lex.start, "Unexpected unfinished SQL query: %s. Missing a semicolon?", bufferRemaining)
+	}
+
+	script.Statements = lex.stmts
+	return script, nil
+}
+
+var knownDialects = map[string]bool{"mysql": true, "postgres": true, "sqlite3": true}
+
+// sectionMatches reports whether a section tagged with the dialects
+// in arg, as in '-- +eioh up mysql,sqlite3', runs on dialect.
+// Untagged sections run everywhere.
+func sectionMatches(arg, dialect string, n int, problem func(int, string, ...interface{})) bool {
+
+	if arg == "" {
+		return true
+	}
+
+	matches := false
+	for _, d := range splitList(arg) {
+		if !knownDialects[d] {
+			problem(n, "unknown dialect %q, expected one of mysql, postgres, sqlite3", d)
+		}
+		if d == dialect {
+			matches = true
+		}
+	}
+	return matches
+}
+
+// addDialects notes the known dialects in a section tag.
+func (s *sqlScript) addDialects(arg string) {
+	for _, d := range splitList(arg) {
+		if knownDialects[d] && !containsString(s.Dialects, d) {
+			s.Dialects = append(s.Dialects, d)
+		}
+	}
+}
+
+func splitSQLStatements(t *migrationText, direction bool, dialect string) *sqlScript {
+
+	script, err := scanMigration(t, direction, dialect)
+	if err != nil {
+		log.Fatalf("scanning migration: %v", err)
 	}
 
-	if bufferRemaining := strings.TrimSpace(buf.String()); len(bufferRemaining) > 0 {
-		log.Printf("WARNING: Unexpected unfinished SQL query: %s. Missing a semicolon?\n", bufferRemaining)
+	for _, p := range script.Problems {
+		log.Printf("WARNING: line %d: %s\n", p.Line, p.Msg)
 	}
 
-	if upSections == 0 && downSections == 0 {
+	if script.Up == 0 && script.Down == 0 {
 		log.Fatalf(`ERROR: no up/down annotations found, so no statements were executed.
 			See https://bitbucket.org/liamstask/eioh/overview for details.`)
 	}
 
-	return
+	return script
 }
 
 
@@ -140,41 +216,73 @@ func RunMigrations(conf *DBConf, migrationsDir string, target int64) error {
 
 func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql.DB) (err error) {
 
+	if conf.SingleTransaction && !conf.Driver.Base.transactionalDDL() {
+		return errors.New(fmt.Sprintf("-single-transaction is not supported with %s, "+
+			"its DDL statements commit implicitly and cannot be rolled back", conf.Driver.Name))
+	}
+
 	current, err := EnsureDBVersion(conf, db)
 
 	if err != nil {
 		return err
 	}
 
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
 
-	migrations, err := CollectMigrations(migrationsDir, current, target)
-	
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
 
+	ms, direction, err := pendingMigrations(conf, db, migrationsDir, current, target)
 	if err != nil {
 		return err
 	}
-	fmt.Println(migrations);
+	fmt.Println(ms);
 
-	if len(migrations) == 0 {
+	if len(ms) == 0 {
 		fmt.Printf("eioh: no migrations to run. current version: %d\n", current)
 		return nil
 	}
 
-	ms := migrationSorter(migrations)
-	direction := current < target
-	ms.Sort(direction)
+	if direction {
+		if err = checkMigrationRisks(conf, ms); err != nil {
+			return err
+		}
+		if err = printImpactReport(conf, db, ms); err != nil {
+			return err
+		}
+		if conf.Shadow {
+			if err = shadowCheck(conf, db, nil, ms); err != nil {
+				return err
+			}
+		}
+	}
+
+	var batch int64
+	if err = conf.Driver.Base.nextBatchQuery(db).Scan(&batch); err != nil {
+		return err
+	}
 
-	fmt.Printf("eioh: migrating db environment '%v', current version: %d, target: %d\n",
-		conf.Env, current, target)
+	fmt.Printf("eioh: migrating db environment '%v', current version: %d, target: %d, batch: %d\n",
+		conf.Env, current, target, batch)
+
+	if conf.SingleTransaction {
+		return runSingleTransaction(conf, db, ms, direction, batch)
+	}
 
 	for _, m := range ms {
 
 		switch filepath.Ext(m.Source) {
 		case ".sql":
-			err = runSQLMigration(conf, db, m.Source, m.Version, direction)
+			err = runSQLMigration(conf, db, m.Source, m.Version, direction, batch)
 		}
 
 		if err != nil {
+			if _, ok := err.(*MigrationError); ok {
+				return err
+			}
 			return errors.New(fmt.Sprintf("FAIL %v, quitting migration", err))
 		}
 
@@ -184,32 +292,233 @@ func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql
 	return nil
 }
 
-func runSQLMigration(conf *DBConf, db *sql.DB, scriptFile string, v int64, direction bool) error {
+// pendingMigrations returns, in the order to run them, the migrations that
+// take the database from current to target, and whether that is up.
+func pendingMigrations(conf *DBConf, db *sql.DB, migrationsDir string, current, target int64) (migrationSorter, bool, error) {
 
-	txn, err := db.Begin()
+	applied, err := AppliedVersions(conf, db)
 	if err != nil {
-		log.Fatal("db.Begin:", err)
+		return nil, false, err
 	}
 
-	f, err := os.Open(scriptFile)
+	migrations, err := CollectMigrations(migrationsDir, current, target)
+	if err != nil {
+		return nil, false, err
+	}
+
+	direction := current <= target
+
+	if direction {
+		outOfOrder, err := collectOutOfOrder(migrationsDir, current, applied)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, m := range outOfOrder {
+			fmt.Printf("eioh: pending migration %s is older than the current version %d\n",
+				filepath.Base(m.Source), current)
+		}
+		if conf.AllowOutOfOrder {
+			migrations = append(migrations, outOfOrder...)
+		} else if len(outOfOrder) > 0 {
+			fmt.Println("eioh: not applying them, run with -allow-out-of-order to do so")
+		}
+	} else {
+		// never roll back a migration that was skipped over
+		migrations = onlyApplied(migrations, applied)
+	}
+
+	ms := migrationSorter(migrations)
+	ms.Sort(direction)
+
+	return ms, direction, nil
+}
+
+// preparedMigration is one direction of a migration, split and ready to run.
+type preparedMigration struct {
+	Source     string
+	Version    int64
+	Direction  bool
+	Statements []Statement
+	Settings   []Setting
+	Checksum   string
+	Rollback   *storedRollback // saved to the history when applying up
+	Kind       string          // kind of history row, applied if empty
+	Operator   string          // who ran it by hand, if anyone
+	Batch      int64           // RunMigrationsOnDb call that applied it, 0 if none
+}
+
+func prepareSQLMigration(conf *DBConf, scriptFile string, v int64, direction bool) (*preparedMigration, error) {
+
+	text, err := readMigration(conf, scriptFile)
+	if err != nil {
+		return nil, err
+	}
+
+	script := splitSQLStatements(text, direction, conf.Driver.Name)
+
+	m := &preparedMigration{
+		Source:     scriptFile,
+		Version:    v,
+		Direction:  direction,
+		Statements: script.Statements,
+		Settings:   script.Settings,
+		Checksum:   checksum(text.SQL),
+	}
+
+	// recorded, so that versions line up across environments, but not run
+	if !runsIn(script.Envs, conf.Env) {
+		fmt.Printf("eioh: %s only runs in %s, recording it as skipped in '%s'\n",
+			filepath.Base(scriptFile), strings.Join(script.Envs, ", "), conf.Env)
+		m.Statements, m.Settings = nil, nil
+		m.Kind = historySkipped
+		if direction {
+			m.Rollback = &storedRollback{}
+		}
+		return m, nil
+	}
+
+	if direction {
+		down, err := scanMigration(text, false, conf.Driver.Name)
+		if err != nil {
+			return nil, err
+		}
+		m.Rollback = &storedRollback{down.Statements, down.Settings}
+	}
+
+	return m, nil
+}
+
+// runsIn reports whether a migration limited to envs runs in env.
+func runsIn(envs []string, env string) bool {
+	if len(envs) == 0 {
+		return true
+	}
+	for _, e := range envs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+func runSQLMigration(conf *DBConf, db *sql.DB, scriptFile string, v int64, direction bool, batch int64) error {
+
+	m, err := prepareSQLMigration(conf, scriptFile, v, direction)
 	if err != nil {
 		log.Fatal(err)
 	}
+	m.Batch = batch
 
-	for _, query := range splitSQLStatements(f, direction) {
-		fmt.Println(query)
-		if _, err = txn.Exec(query); err != nil {
-			txn.Rollback()
-			log.Fatalf("FAIL %s (%v), quitting migration.", filepath.Base(scriptFile), err)
-			return err
+	return execSQLMigration(conf, db, m)
+}
+
+func execSQLMigration(conf *DBConf, db *sql.DB, m *preparedMigration) error {
+
+	if err := preflightCheck(conf, db, m.Statements); err != nil {
+		return err
+	}
+
+	if err := markDirty(conf, db, m.Version, m.Direction); err != nil {
+		log.Fatal("marking migration as started:", err)
+	}
+
+	// session settings are made on a connection of their own, dropped afterwards
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatal("db.Conn:", err)
+	}
+	defer discardSession(conn)
+
+	if !conf.Driver.Base.settingsInTx() {
+		if err = applySettings(conf, conn, m.Settings); err != nil {
+			clearDirty(conf, db, m.Version)
+			return errors.New(fmt.Sprintf("%s: %v", m.Source, err))
 		}
 	}
 
-	if err = FinalizeMigration(conf, txn, direction, v); err != nil {
-		log.Fatalf("error finalizing migration %s, quitting. (%v)", filepath.Base(scriptFile), err)
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Fatal("db.Begin:", err)
 	}
 
-	return nil
+	if err = setLockTimeout(conf, txn); err != nil {
+		txn.Rollback()
+		clearDirty(conf, db, m.Version)
+		return err
+	}
+
+	if n, err := applyMigrationInTx(conf, txn, m); err != nil {
+		txn.Rollback()
+		// the database is still clean if nothing was applied yet,
+		// or if the rollback undid the DDL too
+		if n == 0 || conf.Driver.Base.transactionalDDL() {
+			clearDirty(conf, db, m.Version)
+		}
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		log.Fatalf("error finalizing migration %s, quitting. (%v)", filepath.Base(m.Source), err)
+	}
+
+	return clearDirty(conf, db, m.Version)
+}
+
+func setLockTimeout(conf *DBConf, txn *sql.Tx) error {
+	if conf.LockTimeout <= 0 {
+		return nil
+	}
+	_, err := txn.Exec(conf.Driver.Base.lockTimeoutSql(conf.LockTimeout))
+	return err
+}
+
+// applyMigrationInTx runs m and records it as applied (or rolled back) inside
+// txn, without committing. It returns how many statements were executed.
+func applyMigrationInTx(conf *DBConf, txn *sql.Tx, m *preparedMigration) (int, error) {
+
+	// otherwise made on the connection before txn began, see execSQLMigration
+	if conf.Driver.Base.settingsInTx() {
+		if err := applySettings(conf, txn, m.Settings); err != nil {
+			return 0, errors.New(fmt.Sprintf("%s: %v", m.Source, err))
+		}
+	}
+
+	for i, query := range m.Statements {
+		fmt.Println(query.SQL)
+		if _, err := txn.Exec(query.SQL); err != nil {
+			return i, &MigrationError{query.file(m.Source), query.Line, i + 1, query.SQL, err}
+		}
+	}
+
+	if err := recordVersion(conf, txn, m); err != nil {
+		return len(m.Statements), errors.New(fmt.Sprintf("recording version of %s: %v", filepath.Base(m.Source), err))
+	}
+
+	return len(m.Statements), nil
+}
+
+// MigrationError describes the statement that failed while running a migration.
+type MigrationError struct {
+	Source    string
+	Line      int // line on which the statement starts
+	Statement int // ordinal of the statement within the section, from 1
+	SQL       string
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("%s:%d: statement #%d failed: %v\n\t%s",
+		e.Source, e.Line, e.Statement, e.Err, sqlExcerpt(e.SQL, 80))
+}
+
+// sqlExcerpt collapses whitespace in query and cuts it down to about n bytes.
+func sqlExcerpt(query string, n int) string {
+	s := strings.Join(strings.Fields(query), " ")
+	if len(s) > n {
+		s = s[:n] + "..."
+	}
+	return s
 }
 
 func NumericComponent(name string) (int64, error) {
@@ -257,6 +566,32 @@ func CollectMigrations(dirpath string, current, target int64) (m []*Migration, e
 	return m, nil
 }
 
+// collectOutOfOrder returns the migrations in dirpath that are older
+// than current but were never applied, e.g. merged late from a branch.
+func collectOutOfOrder(dirpath string, current int64, applied map[int64]bool) (m []*Migration, err error) {
+
+	err = filepath.Walk(dirpath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if v, e := NumericComponent(name); e == nil && v < current && !applied[v] {
+			m = append(m, newMigration(v, name))
+		}
+		return nil
+	})
+
+	return m, err
+}
+
+func onlyApplied(ms []*Migration, applied map[int64]bool) (out []*Migration) {
+	for _, m := range ms {
+		if applied[m.Version] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
 func newMigration(v int64, src string) *Migration {
 	return &Migration{v, -1, -1, src}
 }
@@ -305,51 +640,68 @@ type Migration struct {
 	Source   string
 }
 
-func EnsureDBVersion(conf *DBConf, db *sql.DB) (int64, error) {
+// AppliedVersions returns every version whose latest db_version row says it is applied.
+func AppliedVersions(conf *DBConf, db *sql.DB) (map[int64]bool, error) {
 
 	rows, err := conf.Driver.Base.dbVersionQuery(db)
 	if err != nil {
-		if err == ErrTableDoesNotExist {
-			return 0, createVersionTable(conf, db)
-		}
-		return 0, err
+		return nil, err
 	}
 	defer rows.Close()
-	
 
-	toSkip := make([]int64, 0)
+	seen := make(map[int64]bool)
+	applied := make(map[int64]bool)
 
+	// rows are ordered newest first, so the first row seen for a version wins
 	for rows.Next() {
-		
+
 		var row MigrationRecord
 		if err = rows.Scan(&row.VersionId, &row.Status, &row.CreateDate); err != nil {
 			log.Fatal("error scanning rows:", err)
 		}
 
-		skip := false
-		for _, v := range toSkip {
-			if v == row.VersionId {
-				skip = true
-				break
-			}
-		}
-
-		if skip {
+		if seen[row.VersionId] {
 			continue
 		}
+		seen[row.VersionId] = true
 
 		if row.Status {
-			return row.VersionId, nil
+			applied[row.VersionId] = true
 		}
-		toSkip = append(toSkip, row.VersionId)
 	}
 
-	return 0, err
+	return applied, rows.Err()
+}
+
+// EnsureDBVersion returns the highest applied version,
+// creating the version table if it does not exist yet.
+func EnsureDBVersion(conf *DBConf, db *sql.DB) (int64, error) {
+
+	applied, err := AppliedVersions(conf, db)
+	if err != nil {
+		if err == ErrTableDoesNotExist {
+			return 0, createVersionTable(conf, db)
+		}
+		return 0, err
+	}
+
+	current := int64(0)
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+
+	return current, nil
 }
 
 func showDBStatus(conf *DBConf, db *sql.DB) error {
 
-	rows, err := conf.Driver.Base.dbVersionQuery(db)
+	if err := ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	rows, err := conf.Driver.Base.statusQuery(db)
 	if err != nil {
 		if err != ErrTableDoesNotExist {
 			return err
@@ -357,7 +709,7 @@ func showDBStatus(conf *DBConf, db *sql.DB) error {
 		if err = createVersionTable(conf, db); err != nil {
 			return err
 		}
-		if rows, err = conf.Driver.Base.dbVersionQuery(db); err != nil {
+		if rows, err = conf.Driver.Base.statusQuery(db); err != nil {
 			return err
 		}
 	}
@@ -368,7 +720,8 @@ func showDBStatus(conf *DBConf, db *sql.DB) error {
 
 	for rows.Next() {
 		var row MigrationRecord
-		if err = rows.Scan(&row.VersionId, &row.Status, &row.CreateDate); err != nil {
+		var kind, operator string
+		if err = rows.Scan(&row.VersionId, &row.Status, &row.CreateDate, &kind, &operator); err != nil {
 			log.Fatal("error scanning rows:", err)
 		}
 		// a := strconv.FormatBool(row.Status)
@@ -378,8 +731,9 @@ func showDBStatus(conf *DBConf, db *sql.DB) error {
 		}
 		b := strconv.FormatInt(row.VersionId, 10)
 		c := row.CreateDate
+		d := historyNote(kind, operator)
 
-		data = append(data, []string{a, b, c.String()})
+		data = append(data, []string{a, b, c.String(), d})
 		count++
 	}
 
@@ -389,12 +743,33 @@ func showDBStatus(conf *DBConf, db *sql.DB) error {
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Status", "MigrationId", "CreateDate"})
+	table.SetHeader([]string{"Status", "MigrationId", "CreateDate", "Note"})
 	for _, v := range data {
 		table.Append(v)
 	}
 	table.Render()
 
+	if err = checkDirty(conf, db); err != nil {
+		fmt.Println("eioh:", err)
+	}
+
+	applied, err := AppliedVersions(conf, db)
+	if err != nil {
+		return err
+	}
+	current, err := EnsureDBVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	outOfOrder, err := collectOutOfOrder(conf.MigrationsDir, current, applied)
+	if err != nil {
+		return err
+	}
+	for _, m := range outOfOrder {
+		fmt.Printf("eioh: %s is pending but older than the current version %d (apply with up -allow-out-of-order)\n",
+			filepath.Base(m.Source), current)
+	}
+
 	return nil
 }
 
@@ -507,6 +882,12 @@ func GetMostRecentDBVersion(dirpath string) (version int64, err error) {
 }
 
 func CreateMigration(name, dir string, t time.Time) (path string, err error) {
+	return CreateMigrationWithSQL(name, dir, t, nil, nil)
+}
+
+// CreateMigrationWithSQL creates a migration whose up and down sections
+// hold the given statements.
+func CreateMigrationWithSQL(name, dir string, t time.Time, up, down []string) (path string, err error) {
 
 	timestamp := t.Format("20060102150405")
 	filename := fmt.Sprintf("%v_%v.sql", timestamp, name)
@@ -516,7 +897,7 @@ func CreateMigration(name, dir string, t time.Time) (path string, err error) {
 	var tmpl *template.Template
 	tmpl = sqlMigrationTemplate
 
-	path, err = writeTemplateToFile(fpath, tmpl, timestamp)
+	path, err = writeTemplateToFile(fpath, tmpl, migrationSections{up, down})
 
 	return
 }
@@ -532,12 +913,20 @@ func FinalizeMigration(conf *DBConf, txn *sql.Tx, direction bool, v int64) error
 	return txn.Commit()
 }
 
+type migrationSections struct {
+	Up, Down []string
+}
+
 var sqlMigrationTemplate = template.Must(template.New(".sql-migration").Parse(`
 -- +eioh up
 -- SQL in section 'up' is executed when this migration is applied
-
+{{range .Up}}
+{{.}};
+{{end}}
 
 -- +eioh down
 -- SQL section 'down' is executed when this migration is rolled back
-
+{{range .Down}}
+{{.}};
+{{end}}
 `))
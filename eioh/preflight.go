@@ -0,0 +1,122 @@
+package eioh
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DDL実行前に、対象テーブルをつかんだままの長時間トランザクションがないか調べる
+
+// transactions younger than this are expected to finish on their own
+const preflightMinAge = 5
+
+var (
+	ddlRe       = regexp.MustCompile(`(?i)^(ALTER|CREATE|DROP|RENAME|TRUNCATE)\b`)
+	dropTableRe = regexp.MustCompile(`(?i)^(DROP TABLE( IF EXISTS)?|TRUNCATE( TABLE)?|RENAME TABLE) ([\w.$]+)`)
+)
+
+// OpenTransaction is a transaction that a migration's DDL would have to wait for.
+type OpenTransaction struct {
+	ID    int64
+	User  string
+	Age   int64 // seconds
+	Query string
+}
+
+// BlockingTransactionsError is returned when DDL on Table would queue behind Txns.
+type BlockingTransactionsError struct {
+	Table string
+	Txns  []OpenTransaction
+}
+
+func (e *BlockingTransactionsError) Error() string {
+	lines := make([]string, 0, len(e.Txns))
+	for _, t := range e.Txns {
+		lines = append(lines, fmt.Sprintf("\tid=%d user=%s age=%ds query=%s",
+			t.ID, t.User, t.Age, sqlExcerpt(t.Query, 60)))
+	}
+	return fmt.Sprintf("%d open transaction(s) would block DDL on table %s:\n%s",
+		len(e.Txns), e.Table, strings.Join(lines, "\n"))
+}
+
+func scanOpenTransactions(rows *sql.Rows, err error) ([]OpenTransaction, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []OpenTransaction
+	for rows.Next() {
+		var t OpenTransaction
+		if err = rows.Scan(&t.ID, &t.User, &t.Age, &t.Query); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
+
+// ddlTables returns the existing tables that DDL statements in stmts act on.
+func ddlTables(dialect string, stmts []Statement) (tables []string) {
+
+	seen := make(map[string]bool)
+
+	for _, s := range stmts {
+		stripped := stripSQL(dialect, s.SQL)
+		if !ddlRe.MatchString(stripped) {
+			continue
+		}
+
+		_, table := statementTarget(dialect, s.SQL)
+		if m := dropTableRe.FindStringSubmatch(stripped); m != nil {
+			table = m[4]
+		}
+
+		if table != "" && !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+
+	return tables
+}
+
+// preflightCheck makes sure no open transaction holds on to a table that
+// stmts alter, waiting up to conf.PreflightWait seconds for them to finish.
+// When the server cannot tell, it warns and lets the migration go ahead.
+func preflightCheck(conf *DBConf, db *sql.DB, stmts []Statement) error {
+
+	deadline := time.Now().Add(time.Duration(conf.PreflightWait) * time.Second)
+
+	for _, table := range ddlTables(conf.Driver.Name, stmts) {
+
+		schema, name := "", table
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			schema, name = table[:i], table[i+1:]
+		}
+
+		for {
+			txns, err := conf.Driver.Base.openTransactions(db, schema, name, preflightMinAge)
+			if err != nil {
+				// missing privileges, no performance_schema etc. should not stop the migration
+				fmt.Printf("eioh: WARNING: cannot check for open transactions, skipping the preflight check: %v\n", err)
+				return nil
+			}
+			if len(txns) == 0 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return &BlockingTransactionsError{table, txns}
+			}
+
+			fmt.Printf("eioh: waiting for %d open transaction(s) on %s to finish\n", len(txns), table)
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	return nil
+}
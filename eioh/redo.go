@@ -0,0 +1,60 @@
+package eioh
+
+import (
+	"fmt"
+)
+
+// 最新のマイグレーションを戻して再適用する。再適用のチェックはdownの前に済ませる
+
+// RedoMigration rolls back the latest migration and applies it again.
+// The risk and shadow checks of the re-apply run before anything is
+// rolled back, so that a refused redo leaves the database as it was.
+func RedoMigration(conf *DBConf) error {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := EnsureDBVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
+
+	previous, err := GetPreviousDBVersion(conf.MigrationsDir, current)
+	if err != nil {
+		return err
+	}
+
+	ms, err := CollectMigrations(conf.MigrationsDir, previous, current)
+	if err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		fmt.Printf("eioh: no migration file for the current version %d, nothing to redo\n", current)
+		return nil
+	}
+
+	if err = checkMigrationRisks(conf, ms); err != nil {
+		return err
+	}
+	if conf.Shadow {
+		if err = shadowCheck(conf, db, ms, ms); err != nil {
+			return err
+		}
+	}
+
+	if err = RunMigrationsOnDb(conf, conf.MigrationsDir, previous, db); err != nil {
+		return err
+	}
+
+	// checked above already
+	again := *conf
+	again.Shadow = false
+	again.AllowDestructive = true
+	return RunMigrationsOnDb(&again, conf.MigrationsDir, current, db)
+}
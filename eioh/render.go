@@ -0,0 +1,110 @@
+package eioh
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// マイグレーションSQL中の変数 ({{ .Vars.name }} と ${NAME}) を分割前に展開する
+
+// a placeholder, an escaped placeholder, or any other template action
+var placeholderRe = regexp.MustCompile(`\\\{\{|\\\$\{|\{\{\s*\.(Env|Vars\.(\w+))\s*\}\}|\$\{(\w+)\}|\{\{`)
+
+// readMigration returns the migration in path with its includes
+// spliced in, rendered for conf.
+func readMigration(conf *DBConf, path string) (*migrationText, error) {
+
+	b, origins, err := expandIncludes(conf, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &migrationText{path, b, origins}, nil
+}
+
+// renderSQL resolves {{ .Vars.name }} from the environment's vars in
+// conf.yml, {{ .Env }} to its name, and ${NAME} from the vars or else
+// the process environment. An undefined variable is an error, and \{{
+// or \${ stand for the text itself. Only code and quoted identifiers are
+// rendered; string literals and comments are left as they are. Each
+// placeholder is replaced once, values are not rendered again.
+// Rendering never adds or removes a line, so that line numbers still
+// point into the file; other template actions and values spanning
+// lines are rejected.
+func renderSQL(conf *DBConf, path string, src []byte) ([]byte, error) {
+
+	var missing []string
+	var problem *ValidationProblem
+	fail := func(n int, msg string) {
+		if problem == nil {
+			problem = &ValidationProblem{path, n, msg}
+		}
+	}
+
+	lex := newSQLLexer(conf.Driver.Name)
+	lex.trackCode = true
+
+	lines := strings.Split(string(src), "\n")
+	for i, line := range lines {
+		n := i + 1
+		lex.feed(n, line, true)
+
+		var out strings.Builder
+		last := 0
+		for _, loc := range placeholderRe.FindAllStringSubmatchIndex(line, -1) {
+			if !lex.code[loc[0]] {
+				continue
+			}
+			m := line[loc[0]:loc[1]]
+			out.WriteString(line[last:loc[0]])
+			last = loc[1]
+
+			var v string
+			var ok bool
+			switch {
+			case m == `\{{` || m == `\${`:
+				out.WriteString(m[1:])
+				continue
+			case m == "{{":
+				fail(n, "only {{ .Vars.name }} and {{ .Env }} can be used in migrations")
+				out.WriteString(m)
+				continue
+			case loc[2] >= 0 && loc[4] < 0:
+				v, ok = conf.Env, true
+			case loc[4] >= 0:
+				v, ok = conf.Vars[line[loc[4]:loc[5]]]
+			default:
+				name := line[loc[6]:loc[7]]
+				if v, ok = conf.Vars[name]; !ok {
+					v, ok = os.LookupEnv(name)
+				}
+			}
+
+			switch {
+			case !ok:
+				if len(missing) == 0 && problem == nil {
+					problem = &ValidationProblem{Source: path, Line: n}
+				}
+				missing = append(missing, m)
+				out.WriteString(m)
+			case strings.Contains(v, "\n"):
+				fail(n, fmt.Sprintf("the value of %s spans several lines, which would shift the line numbers of the migration", m))
+				out.WriteString(m)
+			default:
+				out.WriteString(v)
+			}
+		}
+		out.WriteString(line[last:])
+		lines[i] = out.String()
+	}
+
+	if len(missing) > 0 && problem.Msg == "" {
+		problem.Msg = fmt.Sprintf("undefined variable(s) %s, set them under %s.vars in conf.yml or in the environment",
+			strings.Join(missing, ", "), conf.Env)
+	}
+	if problem != nil {
+		return nil, problem
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,121 @@
+package eioh
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRenderSQL(t *testing.T) {
+
+	os.Setenv("EIOH_TEST_OWNER", "ops")
+	defer os.Unsetenv("EIOH_TEST_OWNER")
+
+	tests := []struct {
+		name    string
+		dialect string
+		vars    map[string]string
+		sql     string
+		want    string
+	}{
+		{
+			name:    "vars and env",
+			dialect: "mysql",
+			vars:    map[string]string{"prefix": "app"},
+			sql:     "CREATE TABLE {{ .Vars.prefix }}_users (id int); -- {{ .Env }}\nSELECT '{{.Env}}', {{.Env}};",
+			want:    "CREATE TABLE app_users (id int); -- {{ .Env }}\nSELECT '{{.Env}}', staging;",
+		},
+		{
+			name:    "environment variables, vars first",
+			dialect: "postgres",
+			vars:    map[string]string{"schema": "s1"},
+			sql:     "GRANT SELECT ON ${schema}.t TO ${EIOH_TEST_OWNER};",
+			want:    "GRANT SELECT ON s1.t TO ops;",
+		},
+		{
+			name:    "quoted identifiers are rendered",
+			dialect: "mysql",
+			vars:    map[string]string{"prefix": "app"},
+			sql:     "CREATE TABLE `{{ .Vars.prefix }}_users` (id int);",
+			want:    "CREATE TABLE `app_users` (id int);",
+		},
+		{
+			name:    "string literals and comments are not",
+			dialect: "postgres",
+			sql:     "SELECT '${missing}', $$ {{ if }} $$; /* {{ .Vars.missing }}\n${missing} */ -- {{",
+			want:    "SELECT '${missing}', $$ {{ if }} $$; /* {{ .Vars.missing }}\n${missing} */ -- {{",
+		},
+		{
+			name:    "values are substituted once",
+			dialect: "sqlite3",
+			vars:    map[string]string{"a": "${b}", "b": "no"},
+			sql:     "SELECT {{ .Vars.a }}, ${a};",
+			want:    "SELECT ${b}, ${b};",
+		},
+		{
+			name:    "escapes",
+			dialect: "mysql",
+			vars:    map[string]string{"a": "x"},
+			sql:     `SELECT \{{ .Vars.a }}, \${a}, ${a};`,
+			want:    "SELECT {{ .Vars.a }}, ${a}, x;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &DBConf{Env: "staging", Driver: DBDriver{Name: tt.dialect}, Vars: tt.vars}
+			got, err := renderSQL(conf, "m.sql", []byte(tt.sql))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSQLProblems(t *testing.T) {
+
+	tests := []struct {
+		name string
+		vars map[string]string
+		sql  string
+		line int
+		msg  string
+	}{
+		{
+			name: "missing var",
+			sql:  "SELECT 1;\nSELECT {{ .Vars.nope }}, ${ALSO_NOT_SET_ANYWHERE};",
+			line: 2,
+			msg:  "undefined variable(s) {{ .Vars.nope }}, ${ALSO_NOT_SET_ANYWHERE}",
+		},
+		{
+			name: "other template actions",
+			sql:  "SELECT 1;\n\n{{ if .Env }}SELECT 2;{{ end }}",
+			line: 3,
+			msg:  "only {{ .Vars.name }} and {{ .Env }}",
+		},
+		{
+			name: "value spanning lines",
+			vars: map[string]string{"cols": "a,\nb"},
+			sql:  "SELECT {{ .Vars.cols }} FROM t;",
+			line: 1,
+			msg:  "spans several lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &DBConf{Env: "staging", Driver: DBDriver{Name: "mysql"}, Vars: tt.vars}
+			_, err := renderSQL(conf, "m.sql", []byte(tt.sql))
+			p, ok := err.(*ValidationProblem)
+			if !ok {
+				t.Fatalf("got %v, want a problem on line %d", err, tt.line)
+			}
+			if p.Source != "m.sql" || p.Line != tt.line || !strings.Contains(p.Msg, tt.msg) {
+				t.Errorf("got %v, want m.sql:%d containing %q", p, tt.line, tt.msg)
+			}
+		})
+	}
+}
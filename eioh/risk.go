@@ -0,0 +1,301 @@
+package eioh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// マイグレーションSQLの静的リスク分析
+
+// levels a risk rule can be configured with in conf.yml
+const (
+	RiskOff   = "off"
+	RiskWarn  = "warn"
+	RiskBlock = "block" // needs -allow-destructive in a protected environment
+)
+
+// RiskRule classifies a single statement. check returns a description
+// of the problem, or "" if the statement is fine.
+type RiskRule struct {
+	Name  string
+	Level string
+	check func(dialect, stmt string) string
+}
+
+var riskRules = []*RiskRule{
+	{"destructive", RiskBlock, checkDestructive},
+	{"table-rewrite", RiskBlock, checkTableRewrite},
+	{"blocking-lock", RiskWarn, checkBlockingLock},
+	{"missing-online-hints", RiskWarn, checkOnlineHints},
+}
+
+// riskRuleByName returns the rule called name, or nil if there is none.
+func riskRuleByName(name string) *RiskRule {
+	for _, r := range riskRules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// riskRuleNames lists the rule names for error messages.
+func riskRuleNames() string {
+	names := make([]string, len(riskRules))
+	for i, r := range riskRules {
+		names[i] = r.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// RiskFinding is a statement matched by a risk rule.
+type RiskFinding struct {
+	Source string
+	Line   int
+	Rule   string
+	Level  string
+	Msg    string
+}
+
+func (f *RiskFinding) String() string {
+	return fmt.Sprintf("%s:%d: [%s] %s: %s", f.Source, f.Line, f.Level, f.Rule, f.Msg)
+}
+
+// RiskyMigrationError is returned by up in a protected environment when a
+// pending migration matches a blocking rule and -allow-destructive is not set.
+type RiskyMigrationError struct {
+	Env      string
+	Findings []*RiskFinding
+}
+
+func (e *RiskyMigrationError) Error() string {
+	return fmt.Sprintf("environment '%s' is protected and %d statement(s) in pending migrations are risky; "+
+		"re-run with -allow-destructive to apply them", e.Env, len(e.Findings))
+}
+
+// riskLevel returns the level of rule r, as overridden in conf.yml.
+func riskLevel(conf *DBConf, r *RiskRule) string {
+	if l, ok := conf.RiskRules[r.Name]; ok {
+		return l
+	}
+	return r.Level
+}
+
+// AnalyzeStatements runs the enabled risk rules against stmts from source.
+func AnalyzeStatements(conf *DBConf, source string, stmts []Statement) (findings []*RiskFinding) {
+
+	for _, s := range stmts {
+		norm := normalizeSQL(conf.Driver.Name, s.SQL)
+		for _, r := range riskRules {
+			level := riskLevel(conf, r)
+			if level == RiskOff {
+				continue
+			}
+			if msg := r.check(conf.Driver.Name, norm); msg != "" {
+				findings = append(findings, &RiskFinding{s.file(source), s.Line, r.Name, level, msg})
+			}
+		}
+	}
+
+	return findings
+}
+
+// AnalyzeMigration runs the risk rules against the up section of scriptFile.
+func AnalyzeMigration(conf *DBConf, scriptFile string) ([]*RiskFinding, error) {
+	findings, _, err := analyzeMigration(conf, scriptFile)
+	return findings, err
+}
+
+// analyzeMigration is AnalyzeMigration, also reporting whether
+// scriptFile runs in conf.Env at all (see '-- +eioh env').
+func analyzeMigration(conf *DBConf, scriptFile string) ([]*RiskFinding, bool, error) {
+
+	text, err := readMigration(conf, scriptFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	script, err := scanMigration(text, true, conf.Driver.Name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return AnalyzeStatements(conf, scriptFile, script.Statements), runsIn(script.Envs, conf.Env), nil
+}
+
+// AnalyzeMigrations runs the risk rules against every migration in dirpath.
+func AnalyzeMigrations(conf *DBConf, dirpath string) (findings []*RiskFinding, err error) {
+
+	err = filepath.Walk(dirpath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, e := NumericComponent(name); e != nil || info.IsDir() {
+			return nil
+		}
+
+		fs, err := AnalyzeMigration(conf, name)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, fs...)
+		return nil
+	})
+
+	return findings, err
+}
+
+// checkMigrationRisks prints the findings for the pending migrations ms and,
+// in a protected environment, refuses blocking ones unless they are allowed.
+func checkMigrationRisks(conf *DBConf, ms []*Migration) error {
+
+	var blocking []*RiskFinding
+
+	for _, m := range ms {
+		findings, runs, err := analyzeMigration(conf, m.Source)
+		if err != nil {
+			return err
+		}
+		// it will only be recorded as skipped here
+		if !runs {
+			continue
+		}
+		for _, f := range findings {
+			fmt.Println("RISK ", f)
+			if f.Level == RiskBlock {
+				blocking = append(blocking, f)
+			}
+		}
+	}
+
+	if len(blocking) > 0 && conf.Protected && !conf.AllowDestructive {
+		return &RiskyMigrationError{conf.Env, blocking}
+	}
+	return nil
+}
+
+var (
+	sqlStringRe       = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	sqlDoubleQuoteRe  = regexp.MustCompile(`"(?:[^"\\]|\\.|"")*"`)
+	sqlLineCommentRe  = regexp.MustCompile(`(?m)(?:--|#)[^\n]*$`)
+	sqlBlockCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	sqlEqualsRe       = regexp.MustCompile(`\s*=\s*`)
+)
+
+// stripSQL removes comments, string contents, identifier quotes and
+// redundant whitespace from stmt.
+func stripSQL(dialect, stmt string) string {
+	s := sqlBlockCommentRe.ReplaceAllString(stmt, " ")
+	s = sqlStringRe.ReplaceAllString(s, "''")
+	if dialect == "mysql" {
+		s = sqlDoubleQuoteRe.ReplaceAllString(s, "''")
+	} else {
+		s = strings.Replace(s, `"`, "", -1)
+	}
+	s = sqlLineCommentRe.ReplaceAllString(s, " ")
+	s = strings.Replace(s, "`", "", -1)
+	s = sqlEqualsRe.ReplaceAllString(s, "=")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeSQL upper-cases the stripped stmt, so that the rules only match SQL keywords.
+func normalizeSQL(dialect, stmt string) string {
+	return strings.ToUpper(stripSQL(dialect, stmt))
+}
+
+var (
+	dropRe        = regexp.MustCompile(`^DROP (TABLE|DATABASE|SCHEMA)\b`)
+	dropClauseRe  = regexp.MustCompile(`\bDROP ([^ ,;]+)`)
+	truncateRe    = regexp.MustCompile(`^TRUNCATE\b`)
+	deleteAllRe   = regexp.MustCompile(`^DELETE FROM [^ ;]+ ?;?$`)
+	alterTableRe  = regexp.MustCompile(`^ALTER TABLE\b`)
+	mysqlCopyRe   = regexp.MustCompile(`\b(MODIFY|CHANGE|CONVERT TO|ENGINE=|ADD PRIMARY KEY|DROP PRIMARY KEY|ORDER BY|ALGORITHM=COPY)\b`)
+	pgTypeRe      = regexp.MustCompile(`\bALTER (COLUMN )?[^ ]+ (SET DATA )?TYPE\b`)
+	mysqlLockRe   = regexp.MustCompile(`^LOCK TABLES?\b|\bLOCK=(EXCLUSIVE|SHARED)\b`)
+	pgIndexRe     = regexp.MustCompile(`^CREATE (UNIQUE )?INDEX\b`)
+	pgConstraint  = regexp.MustCompile(`\bADD CONSTRAINT\b.*\b(FOREIGN KEY|CHECK)\b`)
+	pgSetNotNull  = regexp.MustCompile(`\bSET NOT NULL\b`)
+	algorithmRe   = regexp.MustCompile(`\bALGORITHM=`)
+	lockClauseRe  = regexp.MustCompile(`\bLOCK=`)
+	renameTableRe = regexp.MustCompile(`^RENAME TABLE\b|^ALTER TABLE [^ ]+ RENAME (TO |AS )?[^ ]+;?$`)
+)
+
+func checkDestructive(dialect, stmt string) string {
+	switch {
+	case dropRe.MatchString(stmt):
+		return "drops a " + strings.ToLower(dropRe.FindStringSubmatch(stmt)[1]) + " and all of its data"
+	case alterTableRe.MatchString(stmt) && alterDropsData(stmt) != "":
+		return "drops " + alterDropsData(stmt) + " and its data"
+	case truncateRe.MatchString(stmt):
+		return "truncates a table"
+	case deleteAllRe.MatchString(stmt):
+		return "deletes every row of a table"
+	}
+	return ""
+}
+
+// alterDropsData returns what an ALTER TABLE statement drops
+// together with its data, or "" if it only drops indexes and such.
+func alterDropsData(stmt string) string {
+	for _, m := range dropClauseRe.FindAllStringSubmatch(stmt, -1) {
+		switch m[1] {
+		case "INDEX", "KEY", "CONSTRAINT", "FOREIGN", "PRIMARY", "DEFAULT", "NOT", "CHECK", "IDENTITY", "EXPRESSION":
+			continue
+		case "PARTITION":
+			return "a partition"
+		}
+		return "a column"
+	}
+	return ""
+}
+
+func checkTableRewrite(dialect, stmt string) string {
+	if !alterTableRe.MatchString(stmt) {
+		return ""
+	}
+	switch dialect {
+	case "mysql":
+		if m := mysqlCopyRe.FindString(stmt); m != "" && !strings.Contains(stmt, "ALGORITHM=INPLACE") &&
+			!strings.Contains(stmt, "ALGORITHM=INSTANT") {
+			return fmt.Sprintf("%s may copy the whole table", m)
+		}
+	case "postgres":
+		if pgTypeRe.MatchString(stmt) {
+			return "changing a column type rewrites the whole table"
+		}
+	}
+	return ""
+}
+
+func checkBlockingLock(dialect, stmt string) string {
+	switch dialect {
+	case "mysql":
+		if mysqlLockRe.MatchString(stmt) {
+			return "takes a lock that blocks writes"
+		}
+	case "postgres":
+		if pgIndexRe.MatchString(stmt) && !strings.Contains(stmt, "CONCURRENTLY") {
+			return "CREATE INDEX without CONCURRENTLY blocks writes to the table"
+		}
+		if alterTableRe.MatchString(stmt) && pgConstraint.MatchString(stmt) && !strings.Contains(stmt, "NOT VALID") {
+			return "validating a new constraint holds a lock while scanning the table, consider NOT VALID"
+		}
+		if alterTableRe.MatchString(stmt) && pgSetNotNull.MatchString(stmt) {
+			return "SET NOT NULL holds an exclusive lock while scanning the table"
+		}
+	}
+	return ""
+}
+
+func checkOnlineHints(dialect, stmt string) string {
+	if dialect != "mysql" || !alterTableRe.MatchString(stmt) || renameTableRe.MatchString(stmt) {
+		return ""
+	}
+	if !algorithmRe.MatchString(stmt) || !lockClauseRe.MatchString(stmt) {
+		return "ALTER TABLE without ALGORITHM= and LOCK= may silently fall back to a copying, locking alter"
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package eioh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/go-gypsy/yaml"
+)
+
+func TestRiskRules(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		rule    string
+		dialect string
+		sql     string
+		want    string // part of the finding, "" if the rule must not match
+	}{
+		{"drop table", "destructive", "mysql", "DROP TABLE a;", "drops a table"},
+		{"drop database", "destructive", "postgres", "drop database app;", "drops a database"},
+		{"drop column", "destructive", "mysql", "ALTER TABLE a DROP COLUMN b;", "drops a column"},
+		{"drop column without keyword", "destructive", "mysql", "ALTER TABLE a DROP b;", "drops a column"},
+		{"drop partition", "destructive", "mysql", "ALTER TABLE a DROP PARTITION p0;", "drops a partition"},
+		{"drop index is not destructive", "destructive", "mysql", "ALTER TABLE a DROP INDEX b;", ""},
+		{"drop default is not destructive", "destructive", "postgres", "ALTER TABLE a ALTER COLUMN b DROP DEFAULT;", ""},
+		{"drop in a string", "destructive", "mysql", "INSERT INTO a VALUES ('DROP TABLE b');", ""},
+		{"drop in a comment", "destructive", "postgres", "SELECT 1; -- DROP TABLE b", ""},
+		{"truncate", "destructive", "mysql", "TRUNCATE TABLE a;", "truncates"},
+		{"delete every row", "destructive", "postgres", "DELETE FROM a;", "deletes every row"},
+		{"delete with where", "destructive", "postgres", "DELETE FROM a WHERE id = 1;", ""},
+
+		{"mysql modify", "table-rewrite", "mysql", "ALTER TABLE a MODIFY b bigint;", "MODIFY may copy"},
+		{"mysql engine", "table-rewrite", "mysql", "ALTER TABLE a ENGINE = InnoDB;", "ENGINE= may copy"},
+		{"mysql inplace", "table-rewrite", "mysql", "ALTER TABLE a MODIFY b bigint, ALGORITHM=INPLACE;", ""},
+		{"postgres type change", "table-rewrite", "postgres", "ALTER TABLE a ALTER COLUMN b TYPE bigint;", "rewrites"},
+		{"postgres set data type", "table-rewrite", "postgres", "ALTER TABLE a ALTER b SET DATA TYPE text;", "rewrites"},
+		{"postgres add column", "table-rewrite", "postgres", "ALTER TABLE a ADD COLUMN b int;", ""},
+		{"sqlite has no rewrite rule", "table-rewrite", "sqlite3", "ALTER TABLE a MODIFY b int;", ""},
+
+		{"mysql lock tables", "blocking-lock", "mysql", "LOCK TABLES a WRITE;", "blocks writes"},
+		{"mysql lock exclusive", "blocking-lock", "mysql", "ALTER TABLE a ADD INDEX (b), LOCK=EXCLUSIVE;", "blocks writes"},
+		{"mysql lock none", "blocking-lock", "mysql", "ALTER TABLE a ADD INDEX (b), LOCK=NONE;", ""},
+		{"postgres index", "blocking-lock", "postgres", "CREATE INDEX a_b ON a (b);", "CONCURRENTLY"},
+		{"postgres unique index", "blocking-lock", "postgres", "create unique index a_b on a (b);", "CONCURRENTLY"},
+		{"postgres concurrent index", "blocking-lock", "postgres", "CREATE INDEX CONCURRENTLY a_b ON a (b);", ""},
+		{"postgres foreign key", "blocking-lock", "postgres",
+			"ALTER TABLE a ADD CONSTRAINT a_b FOREIGN KEY (b) REFERENCES b (id);", "NOT VALID"},
+		{"postgres foreign key not valid", "blocking-lock", "postgres",
+			"ALTER TABLE a ADD CONSTRAINT a_b FOREIGN KEY (b) REFERENCES b (id) NOT VALID;", ""},
+		{"postgres set not null", "blocking-lock", "postgres", "ALTER TABLE a ALTER COLUMN b SET NOT NULL;", "SET NOT NULL"},
+		{"mysql index is online", "blocking-lock", "mysql", "CREATE INDEX a_b ON a (b);", ""},
+
+		{"mysql alter without hints", "missing-online-hints", "mysql", "ALTER TABLE a ADD COLUMN b int;", "ALGORITHM="},
+		{"mysql alter with one hint", "missing-online-hints", "mysql", "ALTER TABLE a ADD COLUMN b int, ALGORITHM=INSTANT;", "LOCK="},
+		{"mysql alter with hints", "missing-online-hints", "mysql",
+			"ALTER TABLE a ADD COLUMN b int, ALGORITHM=INPLACE, LOCK=NONE;", ""},
+		{"mysql rename", "missing-online-hints", "mysql", "ALTER TABLE a RENAME TO b;", ""},
+		{"postgres alter", "missing-online-hints", "postgres", "ALTER TABLE a ADD COLUMN b int;", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := riskRuleByName(tt.rule)
+			if r == nil {
+				t.Fatalf("no rule %q", tt.rule)
+			}
+			got := r.check(tt.dialect, normalizeSQL(tt.dialect, tt.sql))
+			switch {
+			case tt.want == "" && got != "":
+				t.Errorf("got %q, want no finding", got)
+			case tt.want != "" && !strings.Contains(got, tt.want):
+				t.Errorf("got %q, want a finding containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRiskRulesFromYaml(t *testing.T) {
+
+	tests := []struct {
+		name string
+		conf string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "no risk map",
+			conf: "development:\n    driver: mysql\n",
+			want: map[string]string{},
+		},
+		{
+			name: "levels",
+			conf: "development:\n    risk:\n        destructive: warn\n        missing-online-hints: off\n",
+			want: map[string]string{"destructive": RiskWarn, "missing-online-hints": RiskOff},
+		},
+		{
+			name: "unknown rule",
+			conf: "development:\n    risk:\n        destructve: warn\n",
+			err:  "development.risk.destructve: unknown rule",
+		},
+		{
+			name: "unknown level",
+			conf: "development:\n    risk:\n        destructive: never\n",
+			err:  `unknown level "never"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := riskRulesFromYaml(yaml.Config(tt.conf), "development")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s: got %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
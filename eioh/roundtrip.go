@@ -0,0 +1,159 @@
+package eioh
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// スクラッチDBで up → down → up を行い、downでスキーマが元に戻るか確かめる
+
+// RoundtripFailure is a migration whose down section does not undo its up section.
+type RoundtripFailure struct {
+	Source  string
+	Missing []string // schema lines the down section did not restore
+	Extra   []string // schema lines the down section left behind
+}
+
+// RoundtripError lists the migrations that failed verify-roundtrip.
+type RoundtripError struct {
+	Failures []*RoundtripFailure
+}
+
+func (e *RoundtripError) Error() string {
+	lines := []string{fmt.Sprintf("%d migration(s) are not reversed by their down section:", len(e.Failures))}
+	for _, f := range e.Failures {
+		lines = append(lines, "\t"+filepath.Base(f.Source))
+		for _, l := range f.Missing {
+			lines = append(lines, "\t\t- "+l)
+		}
+		for _, l := range f.Extra {
+			lines = append(lines, "\t\t+ "+l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// VerifyRoundtrip applies each migration pending in the scratch database,
+// rolls it back and checks that the schema is as it was before, then
+// applies it again so that the next migration can build on it.
+func VerifyRoundtrip(conf *DBConf) error {
+
+	if conf.Scratch.Name == "" {
+		return errors.New(fmt.Sprintf("no scratch database configured, set %s.scratch.open in conf.yml", conf.Env))
+	}
+	if conf.Scratch.Name == conf.Driver.Name && conf.Scratch.OpenStr == conf.Driver.OpenStr {
+		return errors.New(fmt.Sprintf("the scratch database of '%s' is the environment's own database", conf.Env))
+	}
+
+	scratch := *conf
+	scratch.Driver = conf.Scratch
+	if scratch.Driver.Base == nil {
+		return errors.New(fmt.Sprintf("unsupported scratch driver %q", scratch.Driver.Name))
+	}
+
+	db, err := OpenDBFromDBConf(&scratch)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := EnsureDBVersion(&scratch, db)
+	if err != nil {
+		return err
+	}
+	if err = checkDirty(&scratch, db); err != nil {
+		return err
+	}
+	if err = ensureHistoryTable(&scratch, db); err != nil {
+		return err
+	}
+
+	target, err := GetMostRecentDBVersion(conf.MigrationsDir)
+	if err != nil {
+		return err
+	}
+
+	ms, _, err := pendingMigrations(&scratch, db, conf.MigrationsDir, current, target)
+	if err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		fmt.Printf("eioh: no migrations to verify, scratch database is at version %d\n", current)
+		return nil
+	}
+
+	var batch int64
+	if err = scratch.Driver.Base.nextBatchQuery(db).Scan(&batch); err != nil {
+		return err
+	}
+
+	fmt.Printf("eioh: verifying %d migration(s) against scratch database (%s)\n", len(ms), scratch.Driver.Name)
+
+	var failures []*RoundtripFailure
+
+	for _, m := range ms {
+
+		before, err := schemaSnapshot(&scratch, db)
+		if err != nil {
+			return err
+		}
+
+		for _, direction := range []bool{true, false} {
+			if err = runSQLMigration(&scratch, db, m.Source, m.Version, direction, batch); err != nil {
+				return err
+			}
+		}
+
+		after, err := schemaSnapshot(&scratch, db)
+		if err != nil {
+			return err
+		}
+
+		missing, extra := diffLines(formatSchema(before), formatSchema(after))
+		if len(missing) > 0 || len(extra) > 0 {
+			failures = append(failures, &RoundtripFailure{m.Source, missing, extra})
+			fmt.Println("FAIL ", filepath.Base(m.Source))
+		} else {
+			fmt.Println("OK   ", filepath.Base(m.Source))
+		}
+
+		if err = runSQLMigration(&scratch, db, m.Source, m.Version, true, batch); err != nil {
+			if len(failures) == 0 {
+				return err
+			}
+			// what the broken down section left behind is in the way
+			fmt.Printf("eioh: cannot re-apply %s (%v), not verifying the rest\n", filepath.Base(m.Source), err)
+			break
+		}
+	}
+
+	if len(failures) > 0 {
+		return &RoundtripError{failures}
+	}
+	return nil
+}
+
+// diffLines returns the lines of a that are not in b, and those of b not in a.
+func diffLines(a, b string) (missing, extra []string) {
+
+	count := make(map[string]int)
+	for _, l := range strings.Split(a, "\n") {
+		count[l]++
+	}
+	for _, l := range strings.Split(b, "\n") {
+		if count[l] > 0 {
+			count[l]--
+		} else if strings.TrimSpace(l) != "" {
+			extra = append(extra, l)
+		}
+	}
+	for _, l := range strings.Split(a, "\n") {
+		if count[l] > 0 && strings.TrimSpace(l) != "" {
+			count[l]--
+			missing = append(missing, l)
+		}
+	}
+	return missing, extra
+}
@@ -0,0 +1,129 @@
+package eioh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// スキーマのスナップショットとschema.<driver>.sqlへのダンプ (eioh自身のテーブルは除く)
+
+// SchemaObject is a table, index or view, and the DDL that creates it.
+type SchemaObject struct {
+	Name  string
+	Table string // the table an index or sequence belongs to, or Name
+	DDL   string
+}
+
+// eioh's own bookkeeping tables are not part of the application schema
+var eiohTables = map[string]bool{
+	"db_version":         true,
+	"db_version_dirty":   true,
+	"db_version_history": true,
+}
+
+// schemaSnapshot returns the objects in db that migrations created.
+func schemaSnapshot(conf *DBConf, db *sql.DB) ([]SchemaObject, error) {
+
+	objs, err := conf.Driver.Base.schemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var app []SchemaObject
+	for _, o := range objs {
+		if !eiohTables[strings.ToLower(o.Table)] {
+			app = append(app, o)
+		}
+	}
+	return app, nil
+}
+
+// formatSchema renders objs as a SQL script, one statement per object.
+func formatSchema(objs []SchemaObject) string {
+	var b strings.Builder
+	for _, o := range objs {
+		b.WriteString(strings.TrimSuffix(strings.TrimSpace(o.DDL), ";"))
+		b.WriteString(";\n\n")
+	}
+	return b.String()
+}
+
+const schemaHeader = "-- written by eioh after every migration run, do not edit by hand\n\n"
+
+// SchemaOutOfDateError is returned by CheckSchema when the committed
+// schema file does not match the database.
+type SchemaOutOfDateError struct {
+	File    string
+	Missing []string // in the database, not in the file
+	Extra   []string // in the file, not in the database
+}
+
+func (e *SchemaOutOfDateError) Error() string {
+	lines := []string{fmt.Sprintf("%s is out of date, run 'eioh dump-schema':", e.File)}
+	for _, l := range e.Missing {
+		lines = append(lines, "\t+ "+l)
+	}
+	for _, l := range e.Extra {
+		lines = append(lines, "\t- "+l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DumpSchema returns the schema of the database as the contents of conf.SchemaFile.
+func DumpSchema(conf *DBConf) (string, error) {
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	objs, err := schemaSnapshot(conf, db)
+	if err != nil {
+		return "", err
+	}
+	return schemaHeader + formatSchema(objs), nil
+}
+
+// WriteSchema writes the schema of the database to conf.SchemaFile.
+func WriteSchema(conf *DBConf) error {
+
+	dump, err := DumpSchema(conf)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(conf.SchemaFile, []byte(dump), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("eioh: wrote %s\n", filepath.Base(conf.SchemaFile))
+	return nil
+}
+
+// CheckSchema makes sure conf.SchemaFile matches the database.
+func CheckSchema(conf *DBConf) error {
+
+	b, err := ioutil.ReadFile(conf.SchemaFile)
+	if os.IsNotExist(err) {
+		return errors.New(fmt.Sprintf("%s does not exist, run 'eioh dump-schema'", conf.SchemaFile))
+	}
+	if err != nil {
+		return err
+	}
+
+	dump, err := DumpSchema(conf)
+	if err != nil {
+		return err
+	}
+
+	if string(b) != dump {
+		missing, extra := diffLines(dump, string(b))
+		return &SchemaOutOfDateError{conf.SchemaFile, missing, extra}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package eioh
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Setting is a session variable set for one migration with '-- +eioh set name=value'.
+type Setting struct {
+	Name  string
+	Value string
+	Line  int
+}
+
+var settingNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func parseSetting(arg string) (Setting, error) {
+
+	i := strings.Index(arg, "=")
+	if i < 0 {
+		return Setting{}, fmt.Errorf("'-- +eioh set' expects name=value, got %q", arg)
+	}
+
+	name, value := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+1:])
+	if !settingNameRe.MatchString(name) {
+		return Setting{}, fmt.Errorf("invalid setting name %q", name)
+	}
+	if value == "" {
+		return Setting{}, fmt.Errorf("no value given for setting %q", name)
+	}
+
+	return Setting{Name: name, Value: value}, nil
+}
+
+// sessionExecer is a transaction, or a connection outside of one.
+type sessionExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// applySettings sets settings on the session running ex.
+func applySettings(conf *DBConf, ex sessionExecer, settings []Setting) error {
+	for _, s := range settings {
+		q := conf.Driver.Base.setSessionSql(s.Name, s.Value)
+		fmt.Println(q)
+		if _, err := ex.ExecContext(context.Background(), q); err != nil {
+			return fmt.Errorf("line %d: %v", s.Line, err)
+		}
+	}
+	return nil
+}
+
+// checkSettingsInTx refuses settings in plan when the migrations share
+// one transaction but the driver can only change settings outside of one.
+func checkSettingsInTx(conf *DBConf, plan []*preparedMigration) error {
+	if conf.Driver.Base.settingsInTx() {
+		return nil
+	}
+	for _, m := range plan {
+		if len(m.Settings) > 0 {
+			return fmt.Errorf("%s:%d: %s cannot change '%s' inside the transaction shared by all migrations",
+				m.Source, m.Settings[0].Line, conf.Driver.Name, m.Settings[0].Name)
+		}
+	}
+	return nil
+}
+
+// discardSession closes conn instead of returning it to the pool, so that
+// the settings and lock timeout made on it don't leak into later migrations.
+// Not every setting can be put back (sqlite pragmas have no DEFAULT).
+func discardSession(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// resetSettingsInTx puts the settings changed by a migration back to their
+// defaults, for migrations that share one transaction.
+func resetSettingsInTx(conf *DBConf, txn *sql.Tx, settings []Setting) error {
+	for _, s := range settings {
+		q := conf.Driver.Base.resetSessionSql(s.Name)
+		if q == "" {
+			continue
+		}
+		if _, err := txn.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
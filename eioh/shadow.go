@@ -0,0 +1,133 @@
+package eioh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// 本番適用前に、同じサーバー上のシャドウDBで保留中のマイグレーションを試す
+
+// withTempDatabase creates an empty database on the environment's server,
+// or a temporary file for sqlite3, calls fn with it and drops it again.
+func withTempDatabase(conf *DBConf, db *sql.DB, fn func(tconf *DBConf, tdb *sql.DB) error) error {
+
+	name := fmt.Sprintf("eioh_shadow_%d", time.Now().Unix())
+
+	open, err := conf.Driver.Base.shadowOpenStr(conf.Driver.OpenStr, name)
+	if err != nil {
+		return err
+	}
+
+	if q := conf.Driver.Base.createShadowSql(name); q != "" {
+		if _, err = db.Exec(q); err != nil {
+			return errors.New(fmt.Sprintf("creating database %s: %v", name, err))
+		}
+	}
+	defer func() {
+		q := conf.Driver.Base.dropShadowSql(name)
+		if q == "" {
+			// a file database, nothing to drop on a server
+			os.Remove(open)
+			return
+		}
+		if _, err := db.Exec(q); err != nil {
+			fmt.Printf("eioh: could not drop database %s: %v\n", name, err)
+		}
+	}()
+
+	tconf := *conf
+	tconf.Driver.OpenStr = open
+
+	tdb, err := OpenDBFromDBConf(&tconf)
+	if err != nil {
+		return err
+	}
+	// closed before the deferred drop, which needs every connection gone
+	defer tdb.Close()
+
+	return fn(&tconf, tdb)
+}
+
+// shadowCheck copies the current schema into a new database on the same
+// server, rolls back down and applies up there, and drops it again.
+// The real run should only go ahead when it returns nil.
+func shadowCheck(conf *DBConf, db *sql.DB, down, up []*Migration) error {
+
+	objs, err := schemaSnapshot(conf, db)
+	if err != nil {
+		return err
+	}
+
+	err = withTempDatabase(conf, db, func(shadow *DBConf, sdb *sql.DB) error {
+
+		if len(down) > 0 {
+			fmt.Printf("eioh: trying %d rollback(s) and %d migration(s) on a shadow database\n", len(down), len(up))
+		} else {
+			fmt.Printf("eioh: trying %d migration(s) on a shadow database\n", len(up))
+		}
+
+		if err := copySchema(sdb, objs); err != nil {
+			return err
+		}
+		if _, err := EnsureDBVersion(shadow, sdb); err != nil {
+			return err
+		}
+		if err := checkDirty(shadow, sdb); err != nil {
+			return err
+		}
+		if err := ensureHistoryTable(shadow, sdb); err != nil {
+			return err
+		}
+
+		for _, m := range down {
+			if err := runSQLMigration(shadow, sdb, m.Source, m.Version, false, 0); err != nil {
+				fmt.Printf("eioh: shadow run failed, nothing was applied to '%s'\n", conf.Env)
+				return err
+			}
+		}
+		for _, m := range up {
+			if err := runSQLMigration(shadow, sdb, m.Source, m.Version, true, 0); err != nil {
+				fmt.Printf("eioh: shadow run failed, nothing was applied to '%s'\n", conf.Env)
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		if _, ok := err.(*MigrationError); ok {
+			return err
+		}
+		return errors.New(fmt.Sprintf("shadow database: %v (set %s.shadow: false in conf.yml to skip it)", err, conf.Env))
+	}
+
+	fmt.Println("eioh: shadow run OK")
+	return nil
+}
+
+// copySchema creates objs in db. Objects that fail, such as a table with a
+// foreign key to one not created yet, are retried after the others.
+func copySchema(db *sql.DB, objs []SchemaObject) error {
+
+	for len(objs) > 0 {
+		var left []SchemaObject
+		var lastErr error
+
+		for _, o := range objs {
+			if _, err := db.Exec(o.DDL); err != nil {
+				left = append(left, o)
+				lastErr = err
+			}
+		}
+
+		if len(left) == len(objs) {
+			return errors.New(fmt.Sprintf("copying %s to the shadow database: %v", left[len(left)-1].Name, lastErr))
+		}
+		objs = left
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package eioh
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// 全マイグレーションを1つのトランザクションで適用する (all or nothing)
+
+// runSingleTransaction applies every migration in ms inside one transaction,
+// so that a failing migration also undoes the ones before it.
+// No dirty marker is needed: on failure the database is left as it was.
+func runSingleTransaction(conf *DBConf, db *sql.DB, ms []*Migration, direction bool, batch int64) error {
+
+	var plan []*preparedMigration
+	var stmts []Statement
+
+	for _, m := range ms {
+		if filepath.Ext(m.Source) != ".sql" {
+			continue
+		}
+		pm, err := prepareSQLMigration(conf, m.Source, m.Version, direction)
+		if err != nil {
+			return err
+		}
+		pm.Batch = batch
+		plan = append(plan, pm)
+		stmts = append(stmts, pm.Statements...)
+	}
+
+	if err := checkSettingsInTx(conf, plan); err != nil {
+		return err
+	}
+
+	if err := preflightCheck(conf, db, stmts); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatal("db.Conn:", err)
+	}
+	defer discardSession(conn)
+
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Fatal("db.Begin:", err)
+	}
+
+	if err = setLockTimeout(conf, txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	for _, m := range plan {
+		if _, err = applyMigrationInTx(conf, txn, m); err == nil {
+			err = resetSettingsInTx(conf, txn, m.Settings)
+		}
+		if err != nil {
+			txn.Rollback()
+			fmt.Printf("eioh: rolled back all %d migration(s) of this run\n", len(plan))
+			if _, ok := err.(*MigrationError); ok {
+				return err
+			}
+			return errors.New(fmt.Sprintf("FAIL %v, quitting migration", err))
+		}
+		fmt.Println("OK   ", filepath.Base(m.Source))
+	}
+
+	if err = txn.Commit(); err != nil {
+		return errors.New(fmt.Sprintf("FAIL committing %d migration(s): %v", len(plan), err))
+	}
+
+	return nil
+}
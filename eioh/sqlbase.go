@@ -3,6 +3,15 @@ package eioh
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 var (
@@ -13,13 +22,45 @@ var (
 type SqlBase interface {
 	createVersionTableSql() string
 	insertVersionSql() string
+	insertVersion(txn *sql.Tx, v int64, status bool) (int64, error)
 	dbVersionQuery(db *sql.DB) (*sql.Rows, error)
+	createDirtyTableSql() string
+	insertDirtySql() string
+	deleteDirtySql() string
+	dirtyQuery(db *sql.DB) (*sql.Rows, error)
+	tableSize(db *sql.DB, schema, table string) (rows, size int64, err error)
+	openTransactions(db *sql.DB, schema, table string, minAge int) ([]OpenTransaction, error)
+	lockTimeoutSql(seconds int) string
+	setSessionSql(name, value string) string
+	resetSessionSql(name string) string
+	createHistoryTableSql() string
+	insertHistorySql() string
+	historyQuery(db *sql.DB) (*sql.Rows, error)
+	statusQuery(db *sql.DB) (*sql.Rows, error)
+	rollbackQuery(db *sql.DB, v int64) *sql.Row
+	nextBatchQuery(db *sql.DB) *sql.Row
+	batchQuery(db *sql.DB) (*sql.Rows, error)
+	transactionalDDL() bool
+	settingsInTx() bool
+	schemaObjects(db *sql.DB) ([]SchemaObject, error)
+	createShadowSql(name string) string
+	dropShadowSql(name string) string
+	shadowOpenStr(open, name string) (string, error)
+	tableColumns(db *sql.DB) ([]Column, error)
+	tableIndexes(db *sql.DB) ([]Index, error)
+	tableConstraints(db *sql.DB) ([]Constraint, error)
+	alterColumnSql(table string, from, to Column) ([]string, error)
+	dropIndexSql(idx Index) string
 }
 
 func baseByName(d string) SqlBase {
 	switch d {
 	case "mysql":
 		return &MySqlBase{}
+	case "postgres":
+		return &PostgresBase{}
+	case "sqlite3":
+		return &Sqlite3Base{}
 	}
 	return nil
 }
@@ -40,6 +81,15 @@ func (m MySqlBase) insertVersionSql() string {
 	return "INSERT INTO db_version (VERSION, STATUS) VALUES (?, ?);"
 }
 
+// insertVersion inserts a db_version row and returns its id.
+func (m MySqlBase) insertVersion(txn *sql.Tx, v int64, status bool) (int64, error) {
+	res, err := txn.Exec(m.insertVersionSql(), v, status)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
 func (m MySqlBase) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
 	rows, err := db.Query("SELECT VERSION, STATUS, CREATEDATE from db_version ORDER BY id DESC")
 
@@ -48,3 +98,961 @@ func (m MySqlBase) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
 	}
 	return rows, err
 }
+
+
+func (m MySqlBase) createDirtyTableSql() string {
+	return `CREATE TABLE db_version_dirty (
+                VERSION bigint NOT NULL,
+                STATUS boolean NOT NULL,
+                CREATEDATE timestamp NULL default now(),
+                PRIMARY KEY(VERSION)
+            );`
+}
+
+func (m MySqlBase) insertDirtySql() string {
+	return "INSERT INTO db_version_dirty (VERSION, STATUS) VALUES (?, ?);"
+}
+
+func (m MySqlBase) deleteDirtySql() string {
+	return "DELETE FROM db_version_dirty WHERE VERSION = ?;"
+}
+
+func (m MySqlBase) dirtyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT VERSION, STATUS, CREATEDATE from db_version_dirty ORDER BY CREATEDATE DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// tableSize uses the estimates in information_schema, so it is cheap even on huge tables.
+func (m MySqlBase) tableSize(db *sql.DB, schema, table string) (rows, size int64, err error) {
+	q := `SELECT COALESCE(TABLE_ROWS, 0), COALESCE(DATA_LENGTH, 0) + COALESCE(INDEX_LENGTH, 0)
+            FROM information_schema.TABLES
+            WHERE TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND TABLE_NAME = ?`
+	err = db.QueryRow(q, schema, table).Scan(&rows, &size)
+	return
+}
+
+// openTransactions lists transactions open for longer than minAge seconds
+// that hold a metadata lock on the table. It needs the metadata lock
+// instrument of performance_schema, which is on by default since MySQL 8.0,
+// and the PROCESS privilege for INNODB_TRX.
+func (m MySqlBase) openTransactions(db *sql.DB, schema, table string, minAge int) ([]OpenTransaction, error) {
+	// with the instrument off the query below finds nothing, which is not the same as nothing open
+	var enabled int
+	err := db.QueryRow(`SELECT COUNT(*) FROM performance_schema.setup_instruments
+            WHERE NAME = 'wait/lock/metadata/sql/mdl' AND ENABLED = 'YES'`).Scan(&enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled == 0 {
+		return nil, errors.New("the performance_schema instrument wait/lock/metadata/sql/mdl is disabled")
+	}
+
+	q := `SELECT DISTINCT t.trx_mysql_thread_id, COALESCE(th.PROCESSLIST_USER, ''),
+                TIMESTAMPDIFF(SECOND, t.trx_started, NOW()), COALESCE(t.trx_query, th.PROCESSLIST_INFO, '')
+            FROM performance_schema.metadata_locks l
+            JOIN performance_schema.threads th ON th.THREAD_ID = l.OWNER_THREAD_ID
+            JOIN information_schema.INNODB_TRX t ON t.trx_mysql_thread_id = th.PROCESSLIST_ID
+            WHERE l.OBJECT_TYPE = 'TABLE'
+                AND l.OBJECT_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE()) AND l.OBJECT_NAME = ?
+                AND th.PROCESSLIST_ID <> CONNECTION_ID()
+                AND t.trx_started < NOW() - INTERVAL ? SECOND`
+	return scanOpenTransactions(db.Query(q, schema, table, minAge))
+}
+
+func (m MySqlBase) lockTimeoutSql(seconds int) string {
+	return fmt.Sprintf("SET SESSION lock_wait_timeout = %d;", seconds)
+}
+
+func (m MySqlBase) setSessionSql(name, value string) string {
+	return fmt.Sprintf("SET SESSION %s = %s;", name, value)
+}
+
+func (m MySqlBase) resetSessionSql(name string) string {
+	return fmt.Sprintf("SET SESSION %s = DEFAULT;", name)
+}
+
+func (m MySqlBase) createHistoryTableSql() string {
+	return `CREATE TABLE db_version_history (
+                ID serial NOT NULL,
+                VERSION bigint NOT NULL,
+                STATUS boolean NOT NULL,
+                SOURCE varchar(255) NOT NULL,
+                CHECKSUM char(64) NOT NULL,
+                DOWN_SQL longtext NULL,
+                KIND varchar(16) NOT NULL,
+                OPERATOR varchar(255) NOT NULL default '',
+                BATCH bigint NOT NULL default 0,
+                VERSION_ID bigint NULL,
+                CREATEDATE timestamp NULL default now(),
+                PRIMARY KEY(id)
+            );`
+}
+
+func (m MySqlBase) insertHistorySql() string {
+	return "INSERT INTO db_version_history (VERSION, STATUS, SOURCE, CHECKSUM, DOWN_SQL, KIND, OPERATOR, BATCH, VERSION_ID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);"
+}
+
+func (m MySqlBase) historyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT VERSION, STATUS, SOURCE, KIND, OPERATOR, CREATEDATE from db_version_history ORDER BY id DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// statusQuery lists the db_version rows, newest first, with the kind and
+// operator of the history row written along with each.
+func (m MySqlBase) statusQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query(`SELECT v.VERSION, v.STATUS, v.CREATEDATE, COALESCE(h.KIND, ''), COALESCE(h.OPERATOR, '')
+            FROM db_version v LEFT JOIN db_version_history h ON h.VERSION_ID = v.ID
+            ORDER BY v.ID DESC`)
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (m MySqlBase) rollbackQuery(db *sql.DB, v int64) *sql.Row {
+	return db.QueryRow(`SELECT SOURCE, CHECKSUM, DOWN_SQL from db_version_history
+            WHERE VERSION = ? AND STATUS = true ORDER BY id DESC LIMIT 1`, v)
+}
+
+func (m MySqlBase) nextBatchQuery(db *sql.DB) *sql.Row {
+	return db.QueryRow("SELECT COALESCE(MAX(BATCH), 0) + 1 from db_version_history")
+}
+
+func (m MySqlBase) batchQuery(db *sql.DB) (*sql.Rows, error) {
+	return db.Query("SELECT VERSION, BATCH from db_version_history WHERE STATUS = true ORDER BY id DESC")
+}
+
+// DDL statements in MySQL commit implicitly, so they can't be rolled back.
+func (m MySqlBase) transactionalDDL() bool {
+	return false
+}
+
+func (m MySqlBase) settingsInTx() bool {
+	return true
+}
+
+var (
+	autoIncrementRe = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+	definerRe       = regexp.MustCompile(` DEFINER=\S+`)
+)
+
+// schemaObjects uses SHOW CREATE, leaving out the AUTO_INCREMENT counter
+// and view definers, which differ between otherwise equal schemas.
+func (m MySqlBase) schemaObjects(db *sql.DB) ([]SchemaObject, error) {
+
+	rows, err := db.Query("SHOW FULL TABLES")
+	if err != nil {
+		return nil, err
+	}
+	var tables, views []string
+	for rows.Next() {
+		var name, kind string
+		if err = rows.Scan(&name, &kind); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if kind == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// SHOW FULL TABLES has no guaranteed order, and the schema file is compared byte for byte
+	sort.Strings(tables)
+	sort.Strings(views)
+
+	var objs []SchemaObject
+	for _, t := range tables {
+		var name, ddl string
+		if err = db.QueryRow(fmt.Sprintf("SHOW CREATE TABLE `%s`", t)).Scan(&name, &ddl); err != nil {
+			return nil, err
+		}
+		objs = append(objs, SchemaObject{t, t, autoIncrementRe.ReplaceAllString(ddl, "")})
+	}
+	// views name every table as `db`.`table`, which would tie the dump
+	// (and a shadow copy of the schema) to this database
+	var dbname string
+	if len(views) > 0 {
+		if err = db.QueryRow("SELECT DATABASE()").Scan(&dbname); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range views {
+		var name, ddl, charset, collation string
+		if err = db.QueryRow(fmt.Sprintf("SHOW CREATE VIEW `%s`", v)).Scan(&name, &ddl, &charset, &collation); err != nil {
+			return nil, err
+		}
+		ddl = strings.Replace(ddl, "`"+dbname+"`.", "", -1)
+		objs = append(objs, SchemaObject{v, v, definerRe.ReplaceAllString(ddl, "")})
+	}
+
+	return objs, nil
+}
+
+func (m MySqlBase) createShadowSql(name string) string {
+	return fmt.Sprintf("CREATE DATABASE `%s`;", name)
+}
+
+func (m MySqlBase) dropShadowSql(name string) string {
+	return fmt.Sprintf("DROP DATABASE IF EXISTS `%s`;", name)
+}
+
+// shadowOpenStr points the DSN open at the database name on the same server.
+func (m MySqlBase) shadowOpenStr(open, name string) (string, error) {
+	cfg, err := mysql.ParseDSN(open)
+	if err != nil {
+		return "", err
+	}
+	cfg.DBName = name
+	return cfg.FormatDSN(), nil
+}
+
+func (m MySqlBase) tableColumns(db *sql.DB) ([]Column, error) {
+	// literal defaults come unquoted, expressions are marked DEFAULT_GENERATED;
+	// QUOTE(NULL) would be the word NULL rather than no default
+	return scanColumns(db.Query(`SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE = 'NO',
+                CASE WHEN COLUMN_DEFAULT IS NULL THEN NULL
+                    WHEN EXTRA LIKE '%DEFAULT_GENERATED%' OR COLUMN_DEFAULT = 'CURRENT_TIMESTAMP'
+                    THEN COLUMN_DEFAULT ELSE QUOTE(COLUMN_DEFAULT) END,
+                TRIM(REPLACE(EXTRA, 'DEFAULT_GENERATED', ''))
+            FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE()
+            ORDER BY TABLE_NAME, ORDINAL_POSITION`))
+}
+
+// tableIndexes rebuilds CREATE INDEX statements from information_schema,
+// as SHOW CREATE TABLE has them inline. The primary key is part of the table.
+func (m MySqlBase) tableIndexes(db *sql.DB) ([]Index, error) {
+
+	rows, err := db.Query(`SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE = 0,
+                GROUP_CONCAT(CONCAT('`+"`"+`', COLUMN_NAME, '`+"`"+`') ORDER BY SEQ_IN_INDEX SEPARATOR ', ')
+            FROM information_schema.STATISTICS
+            WHERE TABLE_SCHEMA = DATABASE() AND INDEX_NAME <> 'PRIMARY'
+            GROUP BY TABLE_NAME, INDEX_NAME, NON_UNIQUE
+            ORDER BY TABLE_NAME, INDEX_NAME`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var idxs []Index
+	for rows.Next() {
+		var idx Index
+		var unique bool
+		var cols string
+		if err = rows.Scan(&idx.Table, &idx.Name, &unique, &cols); err != nil {
+			return nil, err
+		}
+		kind := "INDEX"
+		if unique {
+			kind = "UNIQUE INDEX"
+		}
+		idx.DDL = fmt.Sprintf("CREATE %s `%s` ON `%s` (%s)", kind, idx.Name, idx.Table, cols)
+		idxs = append(idxs, idx)
+	}
+	return idxs, rows.Err()
+}
+
+// tableConstraints reads the primary keys, foreign keys and checks from
+// SHOW CREATE TABLE. Unique keys are indexes in mysql, see tableIndexes.
+func (m MySqlBase) tableConstraints(db *sql.DB) ([]Constraint, error) {
+
+	objs, err := m.schemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var cs []Constraint
+	for _, o := range objs {
+		for _, line := range strings.Split(o.DDL, "\n") {
+			line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+			switch {
+			case strings.HasPrefix(line, "PRIMARY KEY "):
+				cs = append(cs, Constraint{o.Table, "PRIMARY", line})
+			case strings.HasPrefix(line, "CONSTRAINT "):
+				cs = append(cs, Constraint{o.Table, strings.Trim(strings.Fields(line)[1], "`"), line})
+			}
+		}
+	}
+	return cs, nil
+}
+
+func (m MySqlBase) alterColumnSql(table string, from, to Column) ([]string, error) {
+	return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s", table, columnDefSql(to))}, nil
+}
+
+func (m MySqlBase) dropIndexSql(idx Index) string {
+	return fmt.Sprintf("DROP INDEX %s ON %s", idx.Name, idx.Table)
+}
+
+type PostgresBase struct{}
+
+func (p PostgresBase) createVersionTableSql() string {
+	return `CREATE TABLE db_version (
+                id serial NOT NULL,
+                version bigint NOT NULL,
+                status boolean NOT NULL,
+                createdate timestamp NULL default now(),
+                PRIMARY KEY(id)
+            );`
+}
+
+func (p PostgresBase) insertVersionSql() string {
+	return "INSERT INTO db_version (version, status) VALUES ($1, $2);"
+}
+
+// insertVersion inserts a db_version row and returns its id. lib/pq has no LastInsertId.
+func (p PostgresBase) insertVersion(txn *sql.Tx, v int64, status bool) (id int64, err error) {
+	err = txn.QueryRow("INSERT INTO db_version (version, status) VALUES ($1, $2) RETURNING id;", v, status).Scan(&id)
+	return id, err
+}
+
+func (p PostgresBase) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version, status, createdate from db_version ORDER BY id DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (p PostgresBase) createDirtyTableSql() string {
+	return `CREATE TABLE db_version_dirty (
+                version bigint NOT NULL,
+                status boolean NOT NULL,
+                createdate timestamp NULL default now(),
+                PRIMARY KEY(version)
+            );`
+}
+
+func (p PostgresBase) insertDirtySql() string {
+	return "INSERT INTO db_version_dirty (version, status) VALUES ($1, $2);"
+}
+
+func (p PostgresBase) deleteDirtySql() string {
+	return "DELETE FROM db_version_dirty WHERE version = $1;"
+}
+
+func (p PostgresBase) dirtyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version, status, createdate from db_version_dirty ORDER BY createdate DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (p PostgresBase) tableSize(db *sql.DB, schema, table string) (rows, size int64, err error) {
+	q := `SELECT c.reltuples::bigint, pg_total_relation_size(c.oid)
+            FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema()) AND c.relname = $2`
+	err = db.QueryRow(q, schema, table).Scan(&rows, &size)
+	return
+}
+
+func (p PostgresBase) openTransactions(db *sql.DB, schema, table string, minAge int) ([]OpenTransaction, error) {
+	q := `SELECT DISTINCT a.pid, COALESCE(a.usename, ''),
+                EXTRACT(EPOCH FROM now() - a.xact_start)::bigint, COALESCE(a.query, '')
+            FROM pg_locks l
+            JOIN pg_class c ON c.oid = l.relation
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            JOIN pg_stat_activity a ON a.pid = l.pid
+            WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema()) AND c.relname = $2
+                AND a.pid <> pg_backend_pid()
+                AND a.xact_start < now() - $3 * interval '1 second'`
+	return scanOpenTransactions(db.Query(q, schema, table, minAge))
+}
+
+func (p PostgresBase) lockTimeoutSql(seconds int) string {
+	return fmt.Sprintf("SET LOCAL lock_timeout = '%ds';", seconds)
+}
+
+func (p PostgresBase) setSessionSql(name, value string) string {
+	return fmt.Sprintf("SET %s = %s;", name, value)
+}
+
+func (p PostgresBase) resetSessionSql(name string) string {
+	return fmt.Sprintf("RESET %s;", name)
+}
+
+func (p PostgresBase) createHistoryTableSql() string {
+	return `CREATE TABLE db_version_history (
+                id serial NOT NULL,
+                version bigint NOT NULL,
+                status boolean NOT NULL,
+                source varchar(255) NOT NULL,
+                checksum char(64) NOT NULL,
+                down_sql text NULL,
+                kind varchar(16) NOT NULL,
+                operator varchar(255) NOT NULL default '',
+                batch bigint NOT NULL default 0,
+                version_id bigint NULL,
+                createdate timestamp NULL default now(),
+                PRIMARY KEY(id)
+            );`
+}
+
+func (p PostgresBase) insertHistorySql() string {
+	return "INSERT INTO db_version_history (version, status, source, checksum, down_sql, kind, operator, batch, version_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);"
+}
+
+func (p PostgresBase) historyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version, status, source, kind, operator, createdate from db_version_history ORDER BY id DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// statusQuery lists the db_version rows, newest first, with the kind and
+// operator of the history row written along with each.
+func (p PostgresBase) statusQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query(`SELECT v.version, v.status, v.createdate, COALESCE(h.kind, ''), COALESCE(h.operator, '')
+            FROM db_version v LEFT JOIN db_version_history h ON h.version_id = v.id
+            ORDER BY v.id DESC`)
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (p PostgresBase) rollbackQuery(db *sql.DB, v int64) *sql.Row {
+	return db.QueryRow(`SELECT source, checksum, down_sql from db_version_history
+            WHERE version = $1 AND status = true ORDER BY id DESC LIMIT 1`, v)
+}
+
+func (p PostgresBase) nextBatchQuery(db *sql.DB) *sql.Row {
+	return db.QueryRow("SELECT COALESCE(MAX(batch), 0) + 1 from db_version_history")
+}
+
+func (p PostgresBase) batchQuery(db *sql.DB) (*sql.Rows, error) {
+	return db.Query("SELECT version, batch from db_version_history WHERE status = true ORDER BY id DESC")
+}
+
+func (p PostgresBase) transactionalDDL() bool {
+	return true
+}
+
+func (p PostgresBase) settingsInTx() bool {
+	return true
+}
+
+// schemaObjects rebuilds CREATE TABLE statements from the catalog, as
+// postgres has no SHOW CREATE. Sequences and tables come first, then
+// indexes and views.
+func (p PostgresBase) schemaObjects(db *sql.DB) ([]SchemaObject, error) {
+
+	rows, err := db.Query(`SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod),
+                a.attnotnull, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+            LEFT JOIN pg_attrdef d ON d.adrelid = c.oid AND d.adnum = a.attnum
+            WHERE n.nspname = current_schema() AND c.relkind = 'r'
+            ORDER BY c.relname, a.attnum`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	columns := make(map[string][]string)
+	for rows.Next() {
+		var table, name, typ, def string
+		var notNull bool
+		if err = rows.Scan(&table, &name, &typ, &notNull, &def); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		col := fmt.Sprintf("%s %s", name, typ)
+		if notNull {
+			col += " NOT NULL"
+		}
+		if def != "" {
+			col += " DEFAULT " + def
+		}
+		if _, ok := columns[table]; !ok {
+			tables = append(tables, table)
+		}
+		columns[table] = append(columns[table], col)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT c.relname, k.conname, pg_get_constraintdef(k.oid)
+            FROM pg_constraint k
+            JOIN pg_class c ON c.oid = k.conrelid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            WHERE n.nspname = current_schema() AND k.contype IN ('p', 'u', 'f', 'c')
+            ORDER BY c.relname, k.conname`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var table, name, def string
+		if err = rows.Scan(&table, &name, &def); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		columns[table] = append(columns[table], fmt.Sprintf("CONSTRAINT %s %s", name, def))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// sequences first, column defaults refer to them
+	var objs []SchemaObject
+	rows, err = db.Query(`SELECT c.relname, COALESCE(t.relname, c.relname) FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            LEFT JOIN pg_depend d ON d.objid = c.oid AND d.deptype = 'a'
+            LEFT JOIN pg_class t ON t.oid = d.refobjid
+            WHERE n.nspname = current_schema() AND c.relkind = 'S'
+            ORDER BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name, table string
+		if err = rows.Scan(&name, &table); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objs = append(objs, SchemaObject{name, table, "CREATE SEQUENCE " + name})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		ddl := fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", t, strings.Join(columns[t], ",\n  "))
+		objs = append(objs, SchemaObject{t, t, ddl})
+	}
+
+	// indexes backing a constraint are part of the CREATE TABLE already
+	rows, err = db.Query(`SELECT i.indexname, i.tablename, i.indexdef FROM pg_indexes i
+            WHERE i.schemaname = current_schema() AND NOT EXISTS (
+                SELECT 1 FROM pg_constraint k WHERE k.conname = i.indexname)
+            ORDER BY i.tablename, i.indexname`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var o SchemaObject
+		if err = rows.Scan(&o.Name, &o.Table, &o.DDL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT viewname, definition FROM pg_views
+            WHERE schemaname = current_schema() ORDER BY viewname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, def string
+		if err = rows.Scan(&name, &def); err != nil {
+			return nil, err
+		}
+		objs = append(objs, SchemaObject{name, name, fmt.Sprintf("CREATE VIEW %s AS\n%s", name, strings.TrimSuffix(strings.TrimSpace(def), ";"))})
+	}
+
+	return objs, rows.Err()
+}
+
+func (p PostgresBase) createShadowSql(name string) string {
+	return fmt.Sprintf("CREATE DATABASE %s;", name)
+}
+
+func (p PostgresBase) dropShadowSql(name string) string {
+	return fmt.Sprintf("DROP DATABASE IF EXISTS %s;", name)
+}
+
+var pgDBNameRe = regexp.MustCompile(`(^|\s)dbname=('[^']*'|\S*)`)
+
+// shadowOpenStr accepts both URL and key=value connection strings.
+func (p PostgresBase) shadowOpenStr(open, name string) (string, error) {
+	if strings.HasPrefix(open, "postgres://") || strings.HasPrefix(open, "postgresql://") {
+		u, err := url.Parse(open)
+		if err != nil {
+			return "", err
+		}
+		u.Path = "/" + name
+		return u.String(), nil
+	}
+	if pgDBNameRe.MatchString(open) {
+		return pgDBNameRe.ReplaceAllString(open, "${1}dbname="+name), nil
+	}
+	return open + " dbname=" + name, nil
+}
+
+func (p PostgresBase) tableColumns(db *sql.DB) ([]Column, error) {
+	return scanColumns(db.Query(`SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod),
+                a.attnotnull, pg_get_expr(d.adbin, d.adrelid), ''
+            FROM pg_class c
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+            JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+            LEFT JOIN pg_attrdef d ON d.adrelid = c.oid AND d.adnum = a.attnum
+            WHERE n.nspname = current_schema() AND c.relkind = 'r'
+            ORDER BY c.relname, a.attnum`))
+}
+
+func (p PostgresBase) tableIndexes(db *sql.DB) ([]Index, error) {
+	return scanIndexes(db.Query(`SELECT i.tablename, i.indexname, i.indexdef FROM pg_indexes i
+            WHERE i.schemaname = current_schema() AND NOT EXISTS (
+                SELECT 1 FROM pg_constraint k WHERE k.conname = i.indexname)
+            ORDER BY i.tablename, i.indexname`))
+}
+
+// tableConstraints lists primary key, foreign key, unique, check and
+// exclusion constraints. NOT NULL is part of the column.
+func (p PostgresBase) tableConstraints(db *sql.DB) ([]Constraint, error) {
+	return scanConstraints(db.Query(`SELECT t.relname, c.conname, pg_get_constraintdef(c.oid)
+            FROM pg_constraint c
+            JOIN pg_class t ON t.oid = c.conrelid
+            JOIN pg_namespace n ON n.oid = t.relnamespace
+            WHERE n.nspname = current_schema() AND c.contype IN ('p', 'f', 'u', 'c', 'x')
+            ORDER BY t.relname, c.conname`))
+}
+
+func (p PostgresBase) alterColumnSql(table string, from, to Column) ([]string, error) {
+
+	var stmts []string
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", table, to.Name)
+
+	if from.Type != to.Type {
+		stmts = append(stmts, alter+fmt.Sprintf("TYPE %s USING %s::%s", to.Type, to.Name, to.Type))
+	}
+	if from.NotNull != to.NotNull {
+		if to.NotNull {
+			stmts = append(stmts, alter+"SET NOT NULL")
+		} else {
+			stmts = append(stmts, alter+"DROP NOT NULL")
+		}
+	}
+	if from.Default != to.Default {
+		if to.Default.Valid {
+			stmts = append(stmts, alter+"SET DEFAULT "+to.Default.String)
+		} else {
+			stmts = append(stmts, alter+"DROP DEFAULT")
+		}
+	}
+	return stmts, nil
+}
+
+func (p PostgresBase) dropIndexSql(idx Index) string {
+	return fmt.Sprintf("DROP INDEX %s", idx.Name)
+}
+
+type Sqlite3Base struct{}
+
+func (s Sqlite3Base) createVersionTableSql() string {
+	return `CREATE TABLE db_version (
+                ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                VERSION bigint NOT NULL,
+                STATUS boolean NOT NULL,
+                CREATEDATE timestamp NULL default (datetime('now'))
+            );`
+}
+
+func (s Sqlite3Base) insertVersionSql() string {
+	return "INSERT INTO db_version (VERSION, STATUS) VALUES (?, ?);"
+}
+
+// insertVersion inserts a db_version row and returns its id.
+func (s Sqlite3Base) insertVersion(txn *sql.Tx, v int64, status bool) (int64, error) {
+	res, err := txn.Exec(s.insertVersionSql(), v, status)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s Sqlite3Base) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT VERSION, STATUS, CREATEDATE from db_version ORDER BY id DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (s Sqlite3Base) createDirtyTableSql() string {
+	return `CREATE TABLE db_version_dirty (
+                VERSION bigint NOT NULL,
+                STATUS boolean NOT NULL,
+                CREATEDATE timestamp NULL default (datetime('now')),
+                PRIMARY KEY(VERSION)
+            );`
+}
+
+func (s Sqlite3Base) insertDirtySql() string {
+	return "INSERT INTO db_version_dirty (VERSION, STATUS) VALUES (?, ?);"
+}
+
+func (s Sqlite3Base) deleteDirtySql() string {
+	return "DELETE FROM db_version_dirty WHERE VERSION = ?;"
+}
+
+func (s Sqlite3Base) dirtyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT VERSION, STATUS, CREATEDATE from db_version_dirty ORDER BY CREATEDATE DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// tableSize counts the rows, SQLite keeps no cheap per-table size estimate.
+func (s Sqlite3Base) tableSize(db *sql.DB, schema, table string) (rows, size int64, err error) {
+	var name string
+	err = db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name)
+	if err != nil {
+		return
+	}
+	err = db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, name)).Scan(&rows)
+	return
+}
+
+// openTransactions has nothing to report, SQLite serializes writers itself.
+func (s Sqlite3Base) openTransactions(db *sql.DB, schema, table string, minAge int) ([]OpenTransaction, error) {
+	return nil, nil
+}
+
+func (s Sqlite3Base) lockTimeoutSql(seconds int) string {
+	return fmt.Sprintf("PRAGMA busy_timeout = %d;", seconds*1000)
+}
+
+func (s Sqlite3Base) setSessionSql(name, value string) string {
+	return fmt.Sprintf("PRAGMA %s = %s;", name, value)
+}
+
+// pragmas have no DEFAULT to go back to; the connection they were made on
+// is discarded after the migration instead (see discardSession).
+func (s Sqlite3Base) resetSessionSql(name string) string {
+	return ""
+}
+
+func (s Sqlite3Base) createHistoryTableSql() string {
+	return `CREATE TABLE db_version_history (
+                ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                VERSION bigint NOT NULL,
+                STATUS boolean NOT NULL,
+                SOURCE varchar(255) NOT NULL,
+                CHECKSUM char(64) NOT NULL,
+                DOWN_SQL text NULL,
+                KIND varchar(16) NOT NULL,
+                OPERATOR varchar(255) NOT NULL default '',
+                BATCH bigint NOT NULL default 0,
+                VERSION_ID bigint NULL,
+                CREATEDATE timestamp NULL default (datetime('now'))
+            );`
+}
+
+func (s Sqlite3Base) insertHistorySql() string {
+	return "INSERT INTO db_version_history (VERSION, STATUS, SOURCE, CHECKSUM, DOWN_SQL, KIND, OPERATOR, BATCH, VERSION_ID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);"
+}
+
+func (s Sqlite3Base) historyQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT VERSION, STATUS, SOURCE, KIND, OPERATOR, CREATEDATE from db_version_history ORDER BY id DESC")
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+// statusQuery lists the db_version rows, newest first, with the kind and
+// operator of the history row written along with each.
+func (s Sqlite3Base) statusQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query(`SELECT v.VERSION, v.STATUS, v.CREATEDATE, COALESCE(h.KIND, ''), COALESCE(h.OPERATOR, '')
+            FROM db_version v LEFT JOIN db_version_history h ON h.VERSION_ID = v.ID
+            ORDER BY v.ID DESC`)
+
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+	return rows, err
+}
+
+func (s Sqlite3Base) rollbackQuery(db *sql.DB, v int64) *sql.Row {
+	return db.QueryRow(`SELECT SOURCE, CHECKSUM, DOWN_SQL from db_version_history
+            WHERE VERSION = ? AND STATUS = 1 ORDER BY id DESC LIMIT 1`, v)
+}
+
+func (s Sqlite3Base) nextBatchQuery(db *sql.DB) *sql.Row {
+	return db.QueryRow("SELECT COALESCE(MAX(BATCH), 0) + 1 from db_version_history")
+}
+
+func (s Sqlite3Base) batchQuery(db *sql.DB) (*sql.Rows, error) {
+	return db.Query("SELECT VERSION, BATCH from db_version_history WHERE STATUS = 1 ORDER BY id DESC")
+}
+
+func (s Sqlite3Base) transactionalDDL() bool {
+	return true
+}
+
+// some pragmas (foreign_keys, journal_mode...) are silently ignored inside a
+// transaction, so they are made on the connection before it begins.
+func (s Sqlite3Base) settingsInTx() bool {
+	return false
+}
+
+// schemaObjects returns the CREATE statements SQLite keeps in sqlite_master.
+func (s Sqlite3Base) schemaObjects(db *sql.DB) ([]SchemaObject, error) {
+
+	rows, err := db.Query(`SELECT name, tbl_name, sql FROM sqlite_master
+            WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+            ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 ELSE 2 END, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objs []SchemaObject
+	for rows.Next() {
+		var o SchemaObject
+		if err = rows.Scan(&o.Name, &o.Table, &o.DDL); err != nil {
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	return objs, rows.Err()
+}
+
+func (s Sqlite3Base) createShadowSql(name string) string {
+	return ""
+}
+
+func (s Sqlite3Base) dropShadowSql(name string) string {
+	return ""
+}
+
+// shadowOpenStr returns a file in the temporary directory, created on open.
+func (s Sqlite3Base) shadowOpenStr(open, name string) (string, error) {
+	return filepath.Join(os.TempDir(), name+".db"), nil
+}
+
+func (s Sqlite3Base) tableColumns(db *sql.DB) ([]Column, error) {
+	return scanColumns(db.Query(`SELECT m.name, p.name, p.type, p."notnull", p.dflt_value, ''
+            FROM sqlite_master m JOIN pragma_table_info(m.name) p
+            WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+            ORDER BY m.name, p.cid`))
+}
+
+func (s Sqlite3Base) tableIndexes(db *sql.DB) ([]Index, error) {
+	return scanIndexes(db.Query(`SELECT tbl_name, name, sql FROM sqlite_master
+            WHERE type = 'index' AND sql IS NOT NULL
+            ORDER BY tbl_name, name`))
+}
+
+// tableConstraints rebuilds the constraints of every table from its pragmas,
+// and takes CHECK clauses from the CREATE TABLE statement. Constraints are
+// mostly unnamed in sqlite, so each is named by its definition.
+func (s Sqlite3Base) tableConstraints(db *sql.DB) ([]Constraint, error) {
+
+	cs, err := scanConstraints(db.Query(`SELECT m.name, d.def, d.def FROM sqlite_master m JOIN (
+                SELECT t.name AS tbl, 'PRIMARY KEY (' || (SELECT group_concat(name, ', ') FROM (
+                    SELECT p.name FROM pragma_table_info(t.name) p WHERE p.pk > 0 ORDER BY p.pk)) || ')' AS def
+                FROM sqlite_master t WHERE t.type = 'table'
+                    AND EXISTS (SELECT 1 FROM pragma_table_info(t.name) p WHERE p.pk > 0)
+                UNION ALL
+                SELECT t.name, 'FOREIGN KEY (' || group_concat(f."from", ', ') || ') REFERENCES ' || f."table" ||
+                    ' (' || group_concat(f."to", ', ') || ') ON UPDATE ' || f.on_update || ' ON DELETE ' || f.on_delete
+                FROM sqlite_master t JOIN pragma_foreign_key_list(t.name) f WHERE t.type = 'table'
+                GROUP BY t.name, f.id
+                UNION ALL
+                SELECT t.name, 'UNIQUE (' || (SELECT group_concat(name, ', ') FROM (
+                    SELECT ii.name FROM pragma_index_info(il.name) ii ORDER BY ii.seqno)) || ')'
+                FROM sqlite_master t JOIN pragma_index_list(t.name) il WHERE t.type = 'table' AND il.origin = 'u'
+            ) d ON d.tbl = m.name
+            WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+            ORDER BY m.name, d.def`))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT name, sql FROM sqlite_master
+            WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, ddl string
+		if err = rows.Scan(&table, &ddl); err != nil {
+			return nil, err
+		}
+		for _, c := range sqliteChecks(ddl) {
+			cs = append(cs, Constraint{table, c, c})
+		}
+	}
+	return cs, rows.Err()
+}
+
+// sqliteChecks returns the CHECK (...) clauses of a CREATE TABLE statement.
+func sqliteChecks(ddl string) (checks []string) {
+
+	upper := strings.ToUpper(ddl)
+	var quote byte
+	start, depth := -1, 0
+
+	for i := 0; i < len(ddl); i++ {
+		c := ddl[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case start < 0 && strings.HasPrefix(upper[i:], "CHECK") && (i == 0 || !isIdentByte(ddl[i-1])) &&
+			(i+5 == len(ddl) || !isIdentByte(ddl[i+5])):
+			start = i
+			i += 4
+		case start >= 0 && c == '(':
+			depth++
+		case start >= 0 && c == ')':
+			if depth--; depth == 0 {
+				checks = append(checks, strings.Join(strings.Fields(ddl[start:i+1]), " "))
+				start = -1
+			}
+		}
+	}
+	return checks
+}
+
+// alterColumnSql fails, SQLite cannot change a column in place.
+func (s Sqlite3Base) alterColumnSql(table string, from, to Column) ([]string, error) {
+	return nil, errors.New(fmt.Sprintf("sqlite3 cannot alter %s.%s in place, rebuild the table by hand: %s",
+		table, to.Name, columnDefSql(to)))
+}
+
+func (s Sqlite3Base) dropIndexSql(idx Index) string {
+	return fmt.Sprintf("DROP INDEX %s", idx.Name)
+}
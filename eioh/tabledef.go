@@ -0,0 +1,373 @@
+package eioh
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kylelemons/go-gypsy/yaml"
+)
+
+// YAMLのテーブル定義から各方言のDDLを生成する
+
+// TableDef is a table described in a portable YAML table-definition file:
+//
+//	tables:
+//	    - name: users
+//	      columns:
+//	          - name: id
+//	            type: bigint
+//	            primary: true
+//	            auto_increment: true
+//	          - name: email
+//	            type: string(255)
+//	            null: false
+//	            default: ''
+//	          - name: org_id
+//	            type: bigint
+//	      indexes:
+//	          - columns: email
+//	            unique: true
+//	      foreign_keys:
+//	          - columns: org_id
+//	            references: orgs(id)
+//	            on_delete: cascade
+//
+// Defaults are SQL expressions, copied as they are, except that
+// default: "" is the empty string.
+type TableDef struct {
+	Name        string
+	Columns     []ColumnDef
+	Indexes     []IndexDef
+	ForeignKeys []ForeignKeyDef
+}
+
+type ColumnDef struct {
+	Name          string
+	Type          string // a portable type, or any type of the dialect
+	Null          bool
+	Default       string
+	Primary       bool
+	AutoIncrement bool
+}
+
+type IndexDef struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+type ForeignKeyDef struct {
+	Name       string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   string
+	OnUpdate   string
+}
+
+// portable types and what they are called in each dialect,
+// %s is replaced by the type's arguments
+var portableTypes = map[string]map[string]string{
+	"smallint": {"mysql": "smallint", "postgres": "smallint", "sqlite3": "INTEGER"},
+	"integer":  {"mysql": "int", "postgres": "integer", "sqlite3": "INTEGER"},
+	"bigint":   {"mysql": "bigint", "postgres": "bigint", "sqlite3": "INTEGER"},
+	"string":   {"mysql": "varchar(%s)", "postgres": "varchar(%s)", "sqlite3": "TEXT"},
+	"text":     {"mysql": "text", "postgres": "text", "sqlite3": "TEXT"},
+	"boolean":  {"mysql": "tinyint(1)", "postgres": "boolean", "sqlite3": "BOOLEAN"},
+	"date":     {"mysql": "date", "postgres": "date", "sqlite3": "DATE"},
+	"datetime": {"mysql": "datetime", "postgres": "timestamp", "sqlite3": "TIMESTAMP"},
+	"decimal":  {"mysql": "decimal(%s)", "postgres": "numeric(%s)", "sqlite3": "NUMERIC"},
+	"float":    {"mysql": "double", "postgres": "double precision", "sqlite3": "REAL"},
+	"binary":   {"mysql": "blob", "postgres": "bytea", "sqlite3": "BLOB"},
+	"json":     {"mysql": "json", "postgres": "jsonb", "sqlite3": "TEXT"},
+	"uuid":     {"mysql": "char(36)", "postgres": "uuid", "sqlite3": "TEXT"},
+}
+
+// arguments used when a type that takes some is given none
+var defaultTypeArgs = map[string]string{
+	"string": "255",
+}
+
+var (
+	typeRe      = regexp.MustCompile(`^(\w+)\s*(?:\((.*)\))?$`)
+	referenceRe = regexp.MustCompile(`^([\w.]+)\s*\((.+)\)$`)
+)
+
+// ReadTableDefs reads the table definitions in the YAML file path.
+func ReadTableDefs(path string) ([]*TableDef, error) {
+
+	f, err := yaml.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := yaml.Child(f.Root, "tables")
+	if err != nil {
+		return nil, err
+	}
+	list, ok := node.(yaml.List)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s: 'tables' must be a list of tables", path))
+	}
+
+	var defs []*TableDef
+	for i, n := range list {
+		t, err := tableDefFromYaml(n)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: tables[%d]: %v", path, i, err))
+		}
+		defs = append(defs, t)
+	}
+	return defs, nil
+}
+
+func tableDefFromYaml(n yaml.Node) (*TableDef, error) {
+
+	m, ok := n.(yaml.Map)
+	if !ok {
+		return nil, errors.New("expected a map")
+	}
+
+	t := &TableDef{Name: yamlString(m, "name")}
+	if t.Name == "" {
+		return nil, errors.New("missing name")
+	}
+
+	for i, cm := range yamlMaps(m, "columns") {
+		c := ColumnDef{
+			Name:          yamlString(cm, "name"),
+			Type:          yamlString(cm, "type"),
+			Default:       yamlDefault(cm),
+			Primary:       yamlString(cm, "primary") == "true",
+			AutoIncrement: yamlString(cm, "auto_increment") == "true",
+		}
+		// primary key columns can't be null, others can unless told otherwise
+		c.Null = !c.Primary && yamlString(cm, "null") != "false"
+		if c.Name == "" || c.Type == "" {
+			return nil, errors.New(fmt.Sprintf("%s.columns[%d]: name and type are required", t.Name, i))
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	if len(t.Columns) == 0 {
+		return nil, errors.New(fmt.Sprintf("%s: no columns", t.Name))
+	}
+
+	for i, im := range yamlMaps(m, "indexes") {
+		idx := IndexDef{
+			Name:    yamlString(im, "name"),
+			Columns: splitList(yamlString(im, "columns")),
+			Unique:  yamlString(im, "unique") == "true",
+		}
+		if len(idx.Columns) == 0 {
+			return nil, errors.New(fmt.Sprintf("%s.indexes[%d]: columns are required", t.Name, i))
+		}
+		if idx.Name == "" {
+			idx.Name = t.Name + "_" + strings.Join(idx.Columns, "_")
+		}
+		t.Indexes = append(t.Indexes, idx)
+	}
+
+	for i, fm := range yamlMaps(m, "foreign_keys") {
+		fk := ForeignKeyDef{
+			Name:     yamlString(fm, "name"),
+			Columns:  splitList(yamlString(fm, "columns")),
+			OnDelete: strings.ToUpper(yamlString(fm, "on_delete")),
+			OnUpdate: strings.ToUpper(yamlString(fm, "on_update")),
+		}
+		ref := referenceRe.FindStringSubmatch(yamlString(fm, "references"))
+		if len(fk.Columns) == 0 || ref == nil {
+			return nil, errors.New(fmt.Sprintf("%s.foreign_keys[%d]: expected columns and references: table(columns)", t.Name, i))
+		}
+		fk.RefTable, fk.RefColumns = ref[1], splitList(ref[2])
+		if fk.Name == "" {
+			fk.Name = t.Name + "_" + strings.Join(fk.Columns, "_") + "_fkey"
+		}
+		t.ForeignKeys = append(t.ForeignKeys, fk)
+	}
+
+	return t, nil
+}
+
+// yamlString returns the scalar at key in m, without surrounding double quotes.
+func yamlString(m yaml.Map, key string) string {
+	s, ok := m[key].(yaml.Scalar)
+	if !ok {
+		return ""
+	}
+	v := strings.TrimSpace(s.String())
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	return v
+}
+
+// yamlDefault returns the default of column map m. A quoted empty
+// string is the SQL empty string, not a missing default.
+func yamlDefault(m yaml.Map) string {
+	if s, ok := m["default"].(yaml.Scalar); ok && strings.TrimSpace(s.String()) == `""` {
+		return "''"
+	}
+	return yamlString(m, "default")
+}
+
+func yamlMaps(m yaml.Map, key string) (maps []yaml.Map) {
+	list, _ := m[key].(yaml.List)
+	for _, n := range list {
+		if mm, ok := n.(yaml.Map); ok {
+			maps = append(maps, mm)
+		}
+	}
+	return maps
+}
+
+func splitList(s string) (items []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func quoteIdent(dialect, name string) string {
+	if dialect == "mysql" {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+func quoteIdents(dialect string, names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdent(dialect, n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// columnType translates a portable type to dialect. Types that
+// are not portable are assumed to be the dialect's own.
+func columnType(dialect string, c ColumnDef) (string, error) {
+
+	typ := c.Type
+	if m := typeRe.FindStringSubmatch(c.Type); m != nil {
+		if names, ok := portableTypes[strings.ToLower(m[1])]; ok {
+			if typ, ok = names[dialect]; !ok {
+				return "", errors.New(fmt.Sprintf("unsupported dialect %q", dialect))
+			}
+			if strings.Contains(typ, "%s") {
+				args := m[2]
+				if args == "" {
+					args = defaultTypeArgs[strings.ToLower(m[1])]
+				}
+				if args == "" {
+					return "", errors.New(fmt.Sprintf("column %s: type %s needs arguments, such as %s(10, 2)", c.Name, m[1], m[1]))
+				}
+				typ = fmt.Sprintf(typ, args)
+			}
+		}
+	}
+
+	if c.AutoIncrement && dialect == "postgres" {
+		serial, ok := serialTypes[strings.ToLower(typ)]
+		if !ok {
+			return "", errors.New(fmt.Sprintf("column %s: auto_increment needs an integer type", c.Name))
+		}
+		typ = serial
+	}
+	return typ, nil
+}
+
+// postgres integer types and their auto-incrementing counterparts
+var serialTypes = map[string]string{
+	"smallint": "smallserial",
+	"int2":     "smallserial",
+	"integer":  "serial",
+	"int":      "serial",
+	"int4":     "serial",
+	"bigint":   "bigserial",
+	"int8":     "bigserial",
+}
+
+// TableDefSql compiles t to the dialect's statements creating and dropping it.
+func TableDefSql(dialect string, t *TableDef) (up, down []string, err error) {
+
+	var lines, primary, autoIncrement []string
+
+	for _, c := range t.Columns {
+		typ, err := columnType(dialect, c)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("%s: %v", t.Name, err))
+		}
+
+		line := quoteIdent(dialect, c.Name) + " " + typ
+		if c.AutoIncrement && dialect == "sqlite3" {
+			// the only way to have one in sqlite
+			line = quoteIdent(dialect, c.Name) + " INTEGER PRIMARY KEY AUTOINCREMENT"
+			autoIncrement = append(autoIncrement, c.Name)
+		} else if !c.Null {
+			line += " NOT NULL"
+		}
+		if c.Default != "" {
+			line += " DEFAULT " + c.Default
+		}
+		if c.AutoIncrement && dialect == "mysql" {
+			line += " AUTO_INCREMENT"
+		}
+		if c.Primary {
+			primary = append(primary, c.Name)
+		}
+		lines = append(lines, line)
+	}
+
+	// the column is the primary key then, there is no room for another
+	if len(autoIncrement) > 0 && (len(primary) != 1 || primary[0] != autoIncrement[0] || len(autoIncrement) > 1) {
+		return nil, nil, errors.New(fmt.Sprintf("%s: sqlite3 allows auto_increment only on a column that is the whole primary key", t.Name))
+	}
+	if len(primary) > 0 && len(autoIncrement) == 0 {
+		lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", quoteIdents(dialect, primary)))
+	}
+
+	for _, fk := range t.ForeignKeys {
+		line := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)", quoteIdent(dialect, fk.Name),
+			quoteIdents(dialect, fk.Columns), quoteIdent(dialect, fk.RefTable), quoteIdents(dialect, fk.RefColumns))
+		if fk.OnDelete != "" {
+			line += " ON DELETE " + fk.OnDelete
+		}
+		if fk.OnUpdate != "" {
+			line += " ON UPDATE " + fk.OnUpdate
+		}
+		lines = append(lines, line)
+	}
+
+	up = append(up, fmt.Sprintf("CREATE TABLE %s (\n    %s\n)", quoteIdent(dialect, t.Name), strings.Join(lines, ",\n    ")))
+
+	for _, idx := range t.Indexes {
+		kind := "INDEX"
+		if idx.Unique {
+			kind = "UNIQUE INDEX"
+		}
+		up = append(up, fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, quoteIdent(dialect, idx.Name),
+			quoteIdent(dialect, t.Name), quoteIdents(dialect, idx.Columns)))
+	}
+
+	// the indexes go with the table
+	down = append(down, "DROP TABLE "+quoteIdent(dialect, t.Name))
+
+	return up, down, nil
+}
+
+// TableDefsSql compiles defs in order, dropping them in reverse on the way down.
+func TableDefsSql(dialect string, defs []*TableDef) (up, down []string, err error) {
+
+	for _, t := range defs {
+		u, d, err := TableDefSql(dialect, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		up = append(up, u...)
+		down = append(d, down...)
+	}
+	return up, down, nil
+}
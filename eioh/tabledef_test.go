@@ -0,0 +1,175 @@
+package eioh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/go-gypsy/yaml"
+)
+
+func TestTableDefSql(t *testing.T) {
+
+	users := &TableDef{
+		Name: "users",
+		Columns: []ColumnDef{
+			{Name: "id", Type: "bigint", Primary: true, AutoIncrement: true},
+			{Name: "email", Type: "string(100)", Default: "''"},
+			{Name: "org_id", Type: "integer", Null: true},
+		},
+		Indexes:     []IndexDef{{Name: "users_email", Columns: []string{"email"}, Unique: true}},
+		ForeignKeys: []ForeignKeyDef{{Name: "users_org_fkey", Columns: []string{"org_id"}, RefTable: "orgs", RefColumns: []string{"id"}, OnDelete: "CASCADE"}},
+	}
+
+	tests := []struct {
+		name    string
+		dialect string
+		table   *TableDef
+		up      []string
+		err     string
+	}{
+		{
+			name:    "mysql",
+			dialect: "mysql",
+			table:   users,
+			up: []string{
+				"CREATE TABLE `users` (\n" +
+					"    `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+					"    `email` varchar(100) NOT NULL DEFAULT '',\n" +
+					"    `org_id` int,\n" +
+					"    PRIMARY KEY (`id`),\n" +
+					"    CONSTRAINT `users_org_fkey` FOREIGN KEY (`org_id`) REFERENCES `orgs` (`id`) ON DELETE CASCADE\n)",
+				"CREATE UNIQUE INDEX `users_email` ON `users` (`email`)",
+			},
+		},
+		{
+			name:    "postgres",
+			dialect: "postgres",
+			table:   users,
+			up: []string{
+				"CREATE TABLE \"users\" (\n" +
+					"    \"id\" bigserial NOT NULL,\n" +
+					"    \"email\" varchar(100) NOT NULL DEFAULT '',\n" +
+					"    \"org_id\" integer,\n" +
+					"    PRIMARY KEY (\"id\"),\n" +
+					"    CONSTRAINT \"users_org_fkey\" FOREIGN KEY (\"org_id\") REFERENCES \"orgs\" (\"id\") ON DELETE CASCADE\n)",
+				"CREATE UNIQUE INDEX \"users_email\" ON \"users\" (\"email\")",
+			},
+		},
+		{
+			name:    "sqlite3",
+			dialect: "sqlite3",
+			table:   users,
+			up: []string{
+				"CREATE TABLE \"users\" (\n" +
+					"    \"id\" INTEGER PRIMARY KEY AUTOINCREMENT,\n" +
+					"    \"email\" TEXT NOT NULL DEFAULT '',\n" +
+					"    \"org_id\" INTEGER,\n" +
+					"    CONSTRAINT \"users_org_fkey\" FOREIGN KEY (\"org_id\") REFERENCES \"orgs\" (\"id\") ON DELETE CASCADE\n)",
+				"CREATE UNIQUE INDEX \"users_email\" ON \"users\" (\"email\")",
+			},
+		},
+		{
+			name:    "postgres auto_increment on a type of the dialect",
+			dialect: "postgres",
+			table:   &TableDef{Name: "a", Columns: []ColumnDef{{Name: "id", Type: "int", Primary: true, AutoIncrement: true}}},
+			up:      []string{"CREATE TABLE \"a\" (\n    \"id\" serial NOT NULL,\n    PRIMARY KEY (\"id\")\n)"},
+		},
+		{
+			name:    "postgres auto_increment on a non-integer",
+			dialect: "postgres",
+			table:   &TableDef{Name: "a", Columns: []ColumnDef{{Name: "id", Type: "uuid", Primary: true, AutoIncrement: true}}},
+			err:     "auto_increment needs an integer type",
+		},
+		{
+			name:    "composite primary key",
+			dialect: "postgres",
+			table: &TableDef{Name: "a", Columns: []ColumnDef{
+				{Name: "x", Type: "integer", Primary: true},
+				{Name: "y", Type: "text", Primary: true},
+			}},
+			up: []string{"CREATE TABLE \"a\" (\n    \"x\" integer NOT NULL,\n    \"y\" text NOT NULL,\n    PRIMARY KEY (\"x\", \"y\")\n)"},
+		},
+		{
+			name:    "sqlite3 auto_increment in a composite primary key",
+			dialect: "sqlite3",
+			table: &TableDef{Name: "a", Columns: []ColumnDef{
+				{Name: "id", Type: "integer", Primary: true, AutoIncrement: true},
+				{Name: "y", Type: "text", Primary: true},
+			}},
+			err: "whole primary key",
+		},
+		{
+			name:    "sqlite3 auto_increment beside the primary key",
+			dialect: "sqlite3",
+			table: &TableDef{Name: "a", Columns: []ColumnDef{
+				{Name: "n", Type: "integer", AutoIncrement: true},
+				{Name: "y", Type: "text", Primary: true},
+			}},
+			err: "whole primary key",
+		},
+		{
+			name:    "decimal needs arguments",
+			dialect: "mysql",
+			table:   &TableDef{Name: "a", Columns: []ColumnDef{{Name: "n", Type: "decimal", Null: true}}},
+			err:     "type decimal needs arguments",
+		},
+		{
+			name:    "decimal",
+			dialect: "postgres",
+			table:   &TableDef{Name: "a", Columns: []ColumnDef{{Name: "n", Type: "decimal(10, 2)", Null: true}}},
+			up:      []string{"CREATE TABLE \"a\" (\n    \"n\" numeric(10, 2)\n)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down, err := TableDefSql(tt.dialect, tt.table)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(up, tt.up) {
+				t.Errorf("up:\n got %q\nwant %q", up, tt.up)
+			}
+			if want := []string{"DROP TABLE " + quoteIdent(tt.dialect, tt.table.Name)}; !reflect.DeepEqual(down, want) {
+				t.Errorf("down: got %q, want %q", down, want)
+			}
+		})
+	}
+}
+
+func TestTableDefFromYamlDefaults(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		notNull bool
+	}{
+		{"no default", "name: n\ntype: text\n", "", false},
+		{"sql string", "name: n\ntype: text\ndefault: 'x'\n", "'x'", false},
+		{"quoted sql string", "name: n\ntype: text\ndefault: \"'x'\"\n", "'x'", false},
+		{"empty string", "name: n\ntype: text\nnull: false\ndefault: \"\"\n", "''", true},
+		{"expression", "name: n\ntype: datetime\ndefault: CURRENT_TIMESTAMP\n", "CURRENT_TIMESTAMP", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "name: a\ncolumns:\n    - " + strings.Replace(strings.TrimSuffix(tt.yaml, "\n"), "\n", "\n      ", -1) + "\n"
+			def, err := tableDefFromYaml(yaml.Config(src).Root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := def.Columns[0]
+			if c.Default != tt.want || c.Null == tt.notNull {
+				t.Errorf("got default %q null %v, want %q null %v", c.Default, c.Null, tt.want, !tt.notNull)
+			}
+		})
+	}
+}
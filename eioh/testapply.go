@@ -0,0 +1,175 @@
+package eioh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// 保留中のマイグレーションをトランザクション内で試し、必ずロールバックする
+
+// StatementResult is the outcome of one statement run by TestApply.
+type StatementResult struct {
+	Source    string
+	Direction bool
+	Line      int
+	SQL       string
+	Rows      int64 // -1 when the driver does not report it
+	Err       error
+}
+
+// TestApply runs the pending migrations, and with down their down sections
+// in reverse, inside one transaction that is always rolled back.
+// It returns the first failure, after printing a result per statement.
+func TestApply(conf *DBConf, down bool) error {
+
+	if !conf.Driver.Base.transactionalDDL() {
+		return errors.New(fmt.Sprintf("test-apply is not supported with %s, "+
+			"its DDL statements commit implicitly and cannot be rolled back", conf.Driver.Name))
+	}
+
+	db, err := OpenDBFromDBConf(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := EnsureDBVersion(conf, db)
+	if err != nil {
+		return err
+	}
+	if err = checkDirty(conf, db); err != nil {
+		return err
+	}
+	if err = ensureHistoryTable(conf, db); err != nil {
+		return err
+	}
+
+	target, err := GetMostRecentDBVersion(conf.MigrationsDir)
+	if err != nil {
+		return err
+	}
+
+	ms, _, err := pendingMigrations(conf, db, conf.MigrationsDir, current, target)
+	if err != nil {
+		return err
+	}
+	if len(ms) == 0 {
+		fmt.Printf("eioh: no migrations to test. current version: %d\n", current)
+		return nil
+	}
+
+	var plan []*preparedMigration
+	for _, m := range ms {
+		pm, err := prepareSQLMigration(conf, m.Source, m.Version, true)
+		if err != nil {
+			return err
+		}
+		plan = append(plan, pm)
+	}
+	if down {
+		for i := len(ms) - 1; i >= 0; i-- {
+			pm, err := prepareSQLMigration(conf, ms[i].Source, ms[i].Version, false)
+			if err != nil {
+				return err
+			}
+			plan = append(plan, pm)
+		}
+	}
+
+	if err = checkSettingsInTx(conf, plan); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer discardSession(conn)
+
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// nothing run here is ever committed
+	defer txn.Rollback()
+
+	if err = setLockTimeout(conf, txn); err != nil {
+		return err
+	}
+
+	fmt.Printf("eioh: test-applying %d migration(s) to db environment '%v', current version: %d\n",
+		len(ms), conf.Env, current)
+
+	var results []*StatementResult
+	var failed error
+
+	for _, m := range plan {
+
+		if err = applySettings(conf, txn, m.Settings); err != nil {
+			failed = errors.New(fmt.Sprintf("%s: %v", m.Source, err))
+			break
+		}
+
+		for i, query := range m.Statements {
+			r := &StatementResult{Source: query.file(m.Source), Direction: m.Direction, Line: query.Line, SQL: query.SQL, Rows: -1}
+			results = append(results, r)
+
+			res, err := txn.Exec(query.SQL)
+			if err != nil {
+				r.Err = err
+				failed = &MigrationError{query.file(m.Source), query.Line, i + 1, query.SQL, err}
+				break
+			}
+			if n, err := res.RowsAffected(); err == nil {
+				r.Rows = n
+			}
+		}
+		if failed != nil {
+			break
+		}
+
+		if err = resetSettingsInTx(conf, txn, m.Settings); err != nil {
+			failed = err
+			break
+		}
+	}
+
+	printTestApplyReport(results)
+
+	if err = txn.Rollback(); err != nil {
+		return err
+	}
+	fmt.Println("eioh: rolled back, nothing was committed")
+
+	return failed
+}
+
+func printTestApplyReport(results []*StatementResult) {
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Migration", "Direction", "Statement", "Rows", "Result"})
+
+	for _, r := range results {
+		direction, rows, result := "up", "-", "ok"
+		if !r.Direction {
+			direction = "down"
+		}
+		if r.Rows >= 0 {
+			rows = strconv.FormatInt(r.Rows, 10)
+		}
+		if r.Err != nil {
+			result = "FAIL: " + r.Err.Error()
+		}
+		src := fmt.Sprintf("%s:%d", filepath.Base(r.Source), r.Line)
+		table.Append([]string{src, direction, sqlExcerpt(r.SQL, 50), rows, result})
+	}
+
+	table.Render()
+}
@@ -0,0 +1,155 @@
+package eioh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ValidationProblem is something wrong with a migration file.
+type ValidationProblem struct {
+	Source string
+	Line   int // 0 when the problem concerns the whole file
+	Msg    string
+}
+
+func (p *ValidationProblem) Error() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.Source, p.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.Source, p.Line, p.Msg)
+}
+
+// ValidateMigrations parses every migration in dirpath the same way a real
+// run would, without connecting to the database, and returns all problems found.
+func ValidateMigrations(conf *DBConf, dirpath string) ([]*ValidationProblem, error) {
+
+	var problems []*ValidationProblem
+	seen := make(map[int64]string)
+
+	// files without a version are fine as long as a migration includes them
+	var unversioned []string
+	included := make(map[string]bool)
+
+	err := filepath.Walk(dirpath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(name) != ".sql" {
+			return nil
+		}
+
+		v, err := NumericComponent(name)
+		if err != nil {
+			unversioned = append(unversioned, name)
+			return nil
+		}
+
+		if other, ok := seen[v]; ok {
+			problems = append(problems, &ValidationProblem{Source: name,
+				Msg: fmt.Sprintf("more than one file specifies the migration for version %d (also %s)", v, other)})
+		}
+		seen[v] = name
+
+		ps, err := validateSQLMigration(conf, name, included)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, ps...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range unversioned {
+		if !included[name] {
+			_, err := NumericComponent(name)
+			problems = append(problems, &ValidationProblem{Source: name, Msg: err.Error()})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Source != problems[j].Source {
+			return problems[i].Source < problems[j].Source
+		}
+		return problems[i].Line < problems[j].Line
+	})
+
+	return problems, nil
+}
+
+// validateSQLMigration checks scriptFile, adding the files it includes to included.
+func validateSQLMigration(conf *DBConf, scriptFile string, included map[string]bool) ([]*ValidationProblem, error) {
+
+	var problems []*ValidationProblem
+	reported := make(map[string]bool)
+
+	b, origins, err := expandIncludes(conf, scriptFile, nil)
+	if p, ok := err.(*ValidationProblem); ok {
+		// the file at fault may be an include, which needs no version
+		included[p.Source] = true
+		return []*ValidationProblem{p}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	text := &migrationText{scriptFile, b, origins}
+	for _, o := range origins {
+		included[o.Source] = true
+	}
+
+	// the environment's dialect, then every other one that sections are
+	// tagged with, each read with its own quoting and comment rules
+	dialects := []string{conf.Driver.Name}
+
+	for i := 0; i < len(dialects); i++ {
+		for _, direction := range []bool{true, false} {
+
+			script, err := scanMigration(text, direction, dialects[i])
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range script.Dialects {
+				if !containsString(dialects, d) {
+					dialects = append(dialects, d)
+				}
+			}
+
+			// the up/down counts do not depend on the direction, check them once
+			if i == 0 && direction && script.Up == 0 {
+				problems = append(problems, &ValidationProblem{Msg: "no '-- +eioh up' annotation found"})
+			}
+
+			// annotations outside the sections are seen in both directions and every dialect
+			for _, p := range script.Problems {
+				key := fmt.Sprintf("%s:%d:%s", p.Source, p.Line, p.Msg)
+				if reported[key] {
+					continue
+				}
+				reported[key] = true
+				if i > 0 {
+					p.Msg = fmt.Sprintf("%s (as %s)", p.Msg, dialects[i])
+				}
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	for _, p := range problems {
+		if p.Source == "" {
+			p.Source = scriptFile
+		}
+	}
+	return problems, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}